@@ -0,0 +1,359 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type aggregationKind int
+
+const (
+	aggGroupBy aggregationKind = iota
+	aggSum
+	aggMin
+	aggMax
+	aggAvg
+)
+
+// Aggregation is a GroupBy field or an aggregate function computed by Aggregate
+type Aggregation struct {
+	kind  aggregationKind
+	field string
+}
+
+// GroupBy groups the records on the values of field, or a dotted path to a nested field like "P.Name"
+func GroupBy(field string) *Aggregation {
+	return &Aggregation{kind: aggGroupBy, field: field}
+}
+
+// Sum computes the sum of the values of field, an int64, uint64 or float64 depending on the kind of field
+func Sum(field string) *Aggregation {
+	return &Aggregation{kind: aggSum, field: field}
+}
+
+// Min computes the smallest value of field, of the type of field
+func Min(field string) *Aggregation {
+	return &Aggregation{kind: aggMin, field: field}
+}
+
+// Max computes the largest value of field, of the type of field
+func Max(field string) *Aggregation {
+	return &Aggregation{kind: aggMax, field: field}
+}
+
+// Avg computes the average of the values of field, a float64
+func Avg(field string) *Aggregation {
+	return &Aggregation{kind: aggAvg, field: field}
+}
+
+// AggregateResult holds the results of the aggregate functions for a group of records
+type AggregateResult struct {
+	// Group holds the values of the GroupBy fields, nil when missing
+	Group []interface{}
+	// Count is the number of records in the group
+	Count int
+	// Values holds the results of the aggregate functions in the order they were passed, Min, Max and Avg are
+	// nil when the group holds no value
+	Values []interface{}
+}
+
+// Aggregate computes the aggregate functions over the records of dataType matching query, for each group of
+// records holding the same values for the GroupBy fields, or for all of them without GroupBy.  The records are
+// read one at a time.  When the records are grouped on the query index, the groups are returned in query order,
+// otherwise in ascending order of their values, missing values last.  Missing values, because of a nil pointer
+// along the path, are skipped by the aggregate functions
+func (s *Store) Aggregate(dataType interface{}, query *Query,
+	aggregations ...*Aggregation) ([]*AggregateResult, error) {
+	var results []*AggregateResult
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var err error
+		results, err = s.TxAggregate(tx, dataType, query, aggregations...)
+		return err
+	})
+	return results, err
+}
+
+// TxAggregate allows you to pass in your own bolt transaction to compute aggregate functions
+func (s *Store) TxAggregate(tx *bolt.Tx, dataType interface{}, query *Query,
+	aggregations ...*Aggregation) ([]*AggregateResult, error) {
+	return s.aggregateQuery(tx, dataType, query, aggregations)
+}
+
+// compiledAggregation is an Aggregation with its path compiled for the records
+type compiledAggregation struct {
+	*Aggregation
+	steps  []pathStep
+	number reflect.Kind // numberKind of the field
+}
+
+// compileAggregations compiles the paths of aggregations for records of type tp, returning the GroupBy fields
+// and the aggregate functions
+func compileAggregations(tp reflect.Type, aggregations []*Aggregation) ([]*compiledAggregation,
+	[]*compiledAggregation, error) {
+	var groups, functions []*compiledAggregation
+	for _, agg := range aggregations {
+		if agg == nil || agg.field == "" {
+			return nil, nil, errors.New("aggregation field is empty")
+		}
+		steps, fieldType, err := compilePath(tp, strings.Split(agg.field, "."))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid aggregation field %s: %s", agg.field, err)
+		}
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		var valid bool
+		switch agg.kind {
+		case aggGroupBy:
+			valid = orderedType(fieldType) || fieldType.Kind() == reflect.Bool
+		case aggSum, aggAvg:
+			valid = numberKind(fieldType.Kind()) != reflect.Invalid
+		case aggMin, aggMax:
+			valid = orderedType(fieldType)
+		}
+		if !valid {
+			return nil, nil, fmt.Errorf("aggregation field %s of type %s isn't supported", agg.field, fieldType)
+		}
+
+		compiled := &compiledAggregation{Aggregation: agg, steps: steps, number: numberKind(fieldType.Kind())}
+		if agg.kind == aggGroupBy {
+			groups = append(groups, compiled)
+		} else {
+			functions = append(functions, compiled)
+		}
+	}
+	return groups, functions, nil
+}
+
+// accumulator holds the state of an aggregate function for a group
+type accumulator struct {
+	n        int
+	sumInt   int64
+	sumUint  uint64
+	sumFloat float64
+	extreme  reflect.Value
+}
+
+func (a *accumulator) add(fn *compiledAggregation, value reflect.Value) {
+	a.n++
+	switch fn.kind {
+	case aggSum, aggAvg:
+		switch fn.number {
+		case reflect.Int64:
+			a.sumInt += value.Int()
+		case reflect.Uint64:
+			a.sumUint += value.Uint()
+		default:
+			a.sumFloat += value.Float()
+		}
+	case aggMin, aggMax:
+		if !a.extreme.IsValid() {
+			a.extreme = value
+			return
+		}
+		cmp, _ := compareValues(value, a.extreme)
+		if (fn.kind == aggMin && cmp < 0) || (fn.kind == aggMax && cmp > 0) {
+			a.extreme = value
+		}
+	}
+}
+
+func (a *accumulator) result(fn *compiledAggregation) interface{} {
+	switch fn.kind {
+	case aggSum:
+		switch fn.number {
+		case reflect.Int64:
+			return a.sumInt
+		case reflect.Uint64:
+			return a.sumUint
+		}
+		return a.sumFloat
+	case aggAvg:
+		if a.n == 0 {
+			return nil
+		}
+		return (float64(a.sumInt) + float64(a.sumUint) + a.sumFloat) / float64(a.n)
+	}
+	if !a.extreme.IsValid() {
+		return nil
+	}
+	return a.extreme.Interface()
+}
+
+// aggregateGroup is a group of records being aggregated
+type aggregateGroup struct {
+	values       []reflect.Value // invalid when missing
+	count        int
+	accumulators []accumulator
+}
+
+func (g *aggregateGroup) result(functions []*compiledAggregation) *AggregateResult {
+	result := &AggregateResult{
+		Group:  make([]interface{}, len(g.values)),
+		Count:  g.count,
+		Values: make([]interface{}, len(functions)),
+	}
+	for i, v := range g.values {
+		if v.IsValid() {
+			result.Group[i] = v.Interface()
+		}
+	}
+	for i, fn := range functions {
+		result.Values[i] = g.accumulators[i].result(fn)
+	}
+	return result
+}
+
+// groupedByIndex tells whether the records matching query are walked grouped on the only GroupBy field, because
+// the query index holds its values without collation
+func groupedByIndex(storer Storer, query *Query, groups []*compiledAggregation) bool {
+	anon, ok := storer.(*anonStorer)
+	if !ok || len(groups) != 1 || query.index == Key || len(query.or) > 0 || len(query.orderBy) > 0 {
+		return false
+	}
+	index, ok := anon.indexes[query.index]
+	return ok && index.Collate == "" && anon.paths[query.index] == groups[0].field
+}
+
+func (s *Store) aggregateQuery(source BucketSource, dataType interface{}, query *Query,
+	aggregations []*Aggregation) ([]*AggregateResult, error) {
+	err := checkQuery(&query)
+	if err != nil {
+		return nil, err
+	}
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return nil, err
+	}
+	rType := recordType(dataType)
+	groupFields, functions, err := compileAggregations(rType, aggregations)
+	if err != nil {
+		return nil, err
+	}
+	indexGrouped := groupedByIndex(storer, query, groupFields)
+
+	var groups []*aggregateGroup
+	byValues := make(map[string]*aggregateGroup)
+	var lastValue []byte
+	if mainBkt := source.Bucket([]byte(storer.Type())); mainBkt != nil {
+		err = s.walkKeys(source, storer, mainBkt, rType, query, func(key, value []byte) (bool, error) {
+			record := reflect.New(rType)
+			err := s.decode(mainBkt.Get(key), record.Interface())
+			if err != nil {
+				return false, err
+			}
+			err = s.decodeKey(key, record)
+			if err != nil {
+				return false, err
+			}
+
+			values := make([]reflect.Value, len(groupFields))
+			for i, field := range groupFields {
+				v := reflect.ValueOf(findPathValue(record.Interface(), field.steps))
+				if derefValue(&v) {
+					values[i] = v
+				}
+			}
+
+			var group *aggregateGroup
+			if indexGrouped {
+				// the records holding the same index value are walked one after the other
+				if len(groups) > 0 && bytes.Equal(value, lastValue) {
+					group = groups[len(groups)-1]
+				}
+				lastValue = append(lastValue[:0], value...)
+			} else {
+				groupKey, err := s.groupKey(values)
+				if err != nil {
+					return false, err
+				}
+				group = byValues[groupKey]
+				if group == nil {
+					group = &aggregateGroup{values: values, accumulators: make([]accumulator, len(functions))}
+					byValues[groupKey] = group
+				}
+			}
+			if group == nil {
+				group = &aggregateGroup{values: values, accumulators: make([]accumulator, len(functions))}
+				groups = append(groups, group)
+			}
+
+			group.count++
+			for i, fn := range functions {
+				v := reflect.ValueOf(findPathValue(record.Interface(), fn.steps))
+				if derefValue(&v) {
+					group.accumulators[i].add(fn, v)
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !indexGrouped {
+		for _, group := range byValues {
+			groups = append(groups, group)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			return lessGroup(groups[i], groups[j])
+		})
+	}
+	if len(groups) == 0 && len(groupFields) == 0 {
+		groups = append(groups, &aggregateGroup{accumulators: make([]accumulator, len(functions))})
+	}
+
+	results := make([]*AggregateResult, len(groups))
+	for i, group := range groups {
+		results[i] = group.result(functions)
+	}
+	return results, nil
+}
+
+// groupKey encodes the values of the GroupBy fields of a record into a map key
+func (s *Store) groupKey(values []reflect.Value) (string, error) {
+	var key []byte
+	var n [binary.MaxVarintLen64]byte
+	for _, v := range values {
+		if !v.IsValid() {
+			key = append(key, 0)
+			continue
+		}
+		encoded, err := s.encode(v.Interface())
+		if err != nil {
+			return "", err
+		}
+		key = append(key, 1)
+		key = append(key, n[:binary.PutUvarint(n[:], uint64(len(encoded)))]...)
+		key = append(key, encoded...)
+	}
+	return string(key), nil
+}
+
+// lessGroup tells whether group a sorts before group b, in ascending order of their values, missing values last
+func lessGroup(a, b *aggregateGroup) bool {
+	for i := range a.values {
+		av, bv := a.values[i], b.values[i]
+		switch {
+		case !av.IsValid() && !bv.IsValid():
+			continue
+		case !av.IsValid():
+			return false
+		case !bv.IsValid():
+			return true
+		}
+		if cmp, _ := compareValues(av, bv); cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return false
+}
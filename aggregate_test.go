@@ -0,0 +1,86 @@
+package meson_bolt_localdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_aggregate(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		r := filteredRecord{
+			Group:    []string{"a", "b"}[i%2],
+			FileSize: int64(i * 50),
+			Rate:     float32(i) / 2,
+			Created:  base.Add(time.Duration(i) * time.Hour),
+		}
+		if i%3 == 0 {
+			r.Owner = &filteredOwner{Name: "bob"}
+		}
+		if err := store.Insert(i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query        *Query
+		aggregations []*Aggregation
+		results      []*AggregateResult
+	}{
+		{nil, []*Aggregation{GroupBy("Group"), Sum("FileSize"), Max("Created"), Min("Rate"), Avg("FileSize")},
+			[]*AggregateResult{
+				{Group: []interface{}{"a"}, Count: 5, Values: []interface{}{int64(1000), base.Add(8 * time.Hour),
+					float32(0), float64(200)}},
+				{Group: []interface{}{"b"}, Count: 5, Values: []interface{}{int64(1250), base.Add(9 * time.Hour),
+					float32(0.5), float64(250)}},
+			}},
+		// grouped on the query index, in query order
+		{NewQuery("Group").Range().Desc(), []*Aggregation{GroupBy("Group"), Sum("FileSize")},
+			[]*AggregateResult{
+				{Group: []interface{}{"b"}, Count: 5, Values: []interface{}{int64(1250)}},
+				{Group: []interface{}{"a"}, Count: 5, Values: []interface{}{int64(1000)}},
+			}},
+		{NewQuery("Group").Equal("a"), []*Aggregation{Sum("Rate"), Min("Created")},
+			[]*AggregateResult{
+				{Group: []interface{}{}, Count: 5, Values: []interface{}{float64(10), base}},
+			}},
+		{NewQuery(Key).Where("FileSize", OpGe, 100), []*Aggregation{GroupBy("Owner.Name"), GroupBy("Group")},
+			[]*AggregateResult{
+				{Group: []interface{}{"bob", "a"}, Count: 1, Values: []interface{}{}},
+				{Group: []interface{}{"bob", "b"}, Count: 2, Values: []interface{}{}},
+				{Group: []interface{}{nil, "a"}, Count: 3, Values: []interface{}{}},
+				{Group: []interface{}{nil, "b"}, Count: 2, Values: []interface{}{}},
+			}},
+		{NewQuery("Group").Equal("c"), []*Aggregation{Sum("FileSize"), Max("FileSize"), Avg("Rate")},
+			[]*AggregateResult{
+				{Group: []interface{}{}, Count: 0, Values: []interface{}{int64(0), nil, nil}},
+			}},
+		{NewQuery("Group").Equal("c"), []*Aggregation{GroupBy("Group")}, []*AggregateResult{}},
+	}
+	for i, test := range tests {
+		results, err := store.Aggregate(&filteredRecord{}, test.query, test.aggregations...)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if len(results) == 0 && len(test.results) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(results, test.results) {
+			t.Fatalf("query %d: expected %+v, got %+v", i, test.results, results)
+		}
+	}
+
+	for _, aggregations := range [][]*Aggregation{
+		{Sum("Created")},
+		{GroupBy("Tags")},
+		{Min("Missing")},
+		{Avg("")},
+	} {
+		if _, err := store.Aggregate(&filteredRecord{}, nil, aggregations...); err == nil {
+			t.Fatalf("expected an error for %+v", aggregations[0])
+		}
+	}
+}
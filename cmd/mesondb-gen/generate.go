@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daqnext/meson-bolt-localdb/internal/fieldtag"
+)
+
+const libraryPath = "github.com/daqnext/meson-bolt-localdb"
+
+// basicKinds are the predeclared types written directly by the generated codecs, with the RecordWriter method
+// writing them and the type it takes
+var basicKinds = map[string][2]string{
+	"string":  {"String", "string"},
+	"bool":    {"Bool", "bool"},
+	"int":     {"Int", "int64"},
+	"int8":    {"Int", "int64"},
+	"int16":   {"Int", "int64"},
+	"int32":   {"Int", "int64"},
+	"rune":    {"Int", "int64"},
+	"int64":   {"Int", "int64"},
+	"uint":    {"Uint", "uint64"},
+	"uint8":   {"Uint", "uint64"},
+	"byte":    {"Uint", "uint64"},
+	"uint16":  {"Uint", "uint64"},
+	"uint32":  {"Uint", "uint64"},
+	"uint64":  {"Uint", "uint64"},
+	"uintptr": {"Uint", "uint64"},
+	"float32": {"Float32", "float32"},
+	"float64": {"Float64", "float64"},
+}
+
+// pkg holds the declarations of the parsed package
+type pkg struct {
+	name  string
+	types map[string]ast.Expr
+}
+
+// field is a struct field as seen by reflect
+type field struct {
+	name     string
+	typ      ast.Expr
+	tag      reflect.StructTag
+	embedded bool
+}
+
+func (f field) exported() bool {
+	return ast.IsExported(f.name)
+}
+
+// index is a generated index
+type index struct {
+	name       string
+	accessor   string
+	unique     bool
+	omitZero   bool
+	ignoreZero bool
+	desc       bool
+	collate    string
+}
+
+// generate returns the source of the generated code for types declared in the package in dir, output is the
+// name of the generated file, which is skipped when parsing
+func generate(dir string, types []string, output string) ([]byte, error) {
+	p, err := parsePackage(dir, output)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mesondb-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", p.name)
+	fmt.Fprintf(&buf, "import (\n\tmesondb %q\n)\n", libraryPath)
+
+	for _, name := range types {
+		name = strings.TrimSpace(name)
+		err = p.generateType(&buf, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %s", err)
+	}
+	return src, nil
+}
+
+func parsePackage(dir, output string) (*pkg, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != output
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	p := &pkg{
+		types: make(map[string]ast.Expr),
+	}
+	for name, astPkg := range pkgs {
+		p.name = name
+		for _, file := range astPkg.Files {
+			for _, decl := range file.Decls {
+				decl, ok := decl.(*ast.GenDecl)
+				if !ok {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.TypeSpec); ok && !spec.Assign.IsValid() {
+						p.types[spec.Name.Name] = spec.Type
+					}
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// structFields returns the fields of the struct type expression, or false if it isn't a struct declared in
+// the package
+func (p *pkg) structFields(typ ast.Expr) ([]field, bool) {
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	st, ok := p.types[ident.Name].(*ast.StructType)
+	if !ok {
+		return nil, false
+	}
+
+	var fields []field
+	for _, f := range st.Fields.List {
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			value, err := strconv.Unquote(f.Tag.Value)
+			if err == nil {
+				tag = reflect.StructTag(value)
+			}
+		}
+		if len(f.Names) == 0 {
+			fields = append(fields, field{name: typeName(f.Type), typ: f.Type, tag: tag, embedded: true})
+			continue
+		}
+		for _, name := range f.Names {
+			fields = append(fields, field{name: name.Name, typ: f.Type, tag: tag})
+		}
+	}
+	return fields, true
+}
+
+// typeName returns the name of an embedded field
+func typeName(typ ast.Expr) string {
+	switch typ := typ.(type) {
+	case *ast.StarExpr:
+		return typeName(typ.X)
+	case *ast.SelectorExpr:
+		return typ.Sel.Name
+	case *ast.Ident:
+		return typ.Name
+	}
+	return ""
+}
+
+// deref removes one level of pointer from typ
+func deref(typ ast.Expr) ast.Expr {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		return star.X
+	}
+	return typ
+}
+
+// lookupField finds a field by name like reflect.Type.FieldByName, returning the chain of embedded fields
+// leading to it
+func (p *pkg) lookupField(typ ast.Expr, name string, visited map[string]bool) ([]field, bool) {
+	fields, ok := p.structFields(deref(typ))
+	if !ok {
+		return nil, false
+	}
+	for _, f := range fields {
+		if f.name == name {
+			return []field{f}, true
+		}
+	}
+	for _, f := range fields {
+		if !f.embedded {
+			continue
+		}
+		embedded := typeName(f.typ)
+		if visited[embedded] {
+			continue
+		}
+		visited[embedded] = true
+		if chain, ok := p.lookupField(f.typ, name, visited); ok {
+			return append([]field{f}, chain...), true
+		}
+	}
+	return nil, false
+}
+
+func (p *pkg) generateType(buf *bytes.Buffer, name string) error {
+	fields, ok := p.structFields(ast.NewIdent(name))
+	if !ok {
+		return fmt.Errorf("%s is not a struct type declared in package %s", name, p.name)
+	}
+
+	for _, f := range fields {
+		switch f.name {
+		case "Indexes", "BoltholdKey", "MarshalMesonDB", "UnmarshalMesonDB":
+			return fmt.Errorf("%s.%s conflicts with a generated method", name, f.name)
+		}
+	}
+
+	lower := strings.ToLower(name[:1]) + name[1:]
+	indexesVar := lower + "Indexes"
+	asFunc := "as" + strings.ToUpper(name[:1]) + name[1:]
+
+	// Indexer, the store names the bucket of the type like it does without the generated code
+	fmt.Fprintf(buf, "\n// Indexes returns the indexes of %s\n", name)
+	fmt.Fprintf(buf, "func (r %s) Indexes() map[string]mesondb.Index {\n\treturn %s\n}\n", name, indexesVar)
+
+	indexes, err := p.indexes(name, fields, nil, nil, map[string]bool{name: true})
+	if err != nil {
+		return err
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].name < indexes[j].name
+	})
+	for i := 1; i < len(indexes); i++ {
+		if indexes[i].name == indexes[i-1].name {
+			return fmt.Errorf("%s has two indexes named %s", name, indexes[i].name)
+		}
+	}
+
+	fmt.Fprintf(buf, "\nvar %s = map[string]mesondb.Index{\n", indexesVar)
+	for _, idx := range indexes {
+		fmt.Fprintf(buf, "%q: {\n", idx.name)
+		fmt.Fprintf(buf, "ValueFunc: func(value interface{}) interface{} {\n")
+		fmt.Fprintf(buf, "r := %s(value)\nif r == nil {\nreturn nil\n}\n%s},\n", asFunc, idx.accessor)
+		if idx.unique {
+			fmt.Fprintf(buf, "Unique: true,\n")
+		}
+		if idx.omitZero {
+			fmt.Fprintf(buf, "OmitZero: true,\n")
+		}
+		if idx.ignoreZero {
+			fmt.Fprintf(buf, "IgnoreZero: true,\n")
+		}
+		if idx.desc {
+			fmt.Fprintf(buf, "Desc: true,\n")
+		}
+		if idx.collate != "" {
+			fmt.Fprintf(buf, "Collate: %q,\n", idx.collate)
+		}
+		fmt.Fprintf(buf, "},\n")
+	}
+	fmt.Fprintf(buf, "}\n")
+
+	fmt.Fprintf(buf, "\nfunc %s(value interface{}) *%s {\n", asFunc, name)
+	fmt.Fprintf(buf, "switch v := value.(type) {\ncase *%s:\nreturn v\ncase %s:\nreturn &v\n}\nreturn nil\n}\n", name, name)
+
+	// KeyAccessor
+	for _, f := range fields {
+		if ft, _ := fieldtag.Parse(f.tag); ft.Key {
+			fmt.Fprintf(buf, "\n// BoltholdKey returns a pointer to the key field of %s\n", name)
+			fmt.Fprintf(buf, "func (r *%s) BoltholdKey() interface{} {\n\treturn &r.%s\n}\n", name, f.name)
+			break
+		}
+	}
+
+	// codec
+	var marshal, unmarshal bytes.Buffer
+	for _, f := range fields {
+		if !f.exported() || f.name == "_" {
+			continue
+		}
+		method, conv, ok := p.basicKind(f.typ)
+		switch {
+		case isByteSlice(f.typ):
+			fmt.Fprintf(&marshal, "w.Bytes(r.%s)\n", f.name)
+			fmt.Fprintf(&unmarshal, "r.%s = d.Bytes()\n", f.name)
+		case ok && exprString(f.typ) == conv:
+			fmt.Fprintf(&marshal, "w.%s(r.%s)\n", method, f.name)
+			fmt.Fprintf(&unmarshal, "r.%s = d.%s()\n", f.name, method)
+		case ok:
+			fmt.Fprintf(&marshal, "w.%s(%s(r.%s))\n", method, conv, f.name)
+			fmt.Fprintf(&unmarshal, "r.%s = %s(d.%s())\n", f.name, exprString(f.typ), method)
+		default:
+			fmt.Fprintf(&marshal, "w.Value(r.%s)\n", f.name)
+			fmt.Fprintf(&unmarshal, "d.Value(&r.%s)\n", f.name)
+		}
+	}
+
+	fmt.Fprintf(buf, "\n// MarshalMesonDB encodes %s without reflection\n", name)
+	fmt.Fprintf(buf, "func (r %s) MarshalMesonDB() ([]byte, error) {\n", name)
+	fmt.Fprintf(buf, "w := mesondb.NewRecordWriter(%d)\n%sreturn w.Result()\n}\n", 16*len(fields), marshal.String())
+	fmt.Fprintf(buf, "\n// UnmarshalMesonDB decodes %s without reflection\n", name)
+	fmt.Fprintf(buf, "func (r *%s) UnmarshalMesonDB(data []byte) error {\n", name)
+	fmt.Fprintf(buf, "d := mesondb.NewRecordReader(data)\n%sreturn d.Err()\n}\n", unmarshal.String())
+
+	return nil
+}
+
+// indexes returns the indexes defined by fields, walking nested structs like the reflection based storer
+func (p *pkg) indexes(root string, fields []field, parent, prefix []string, visited map[string]bool) ([]index,
+	error) {
+	var indexes []index
+	for _, f := range fields {
+		if !f.exported() && !f.embedded {
+			continue
+		}
+		path := append(append([]string{}, parent...), f.name)
+
+		ft, err := fieldtag.Parse(f.tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag on %s.%s: %s", root, strings.Join(path, "."), err)
+		}
+
+		if ft.Index || ft.Unique {
+			idx, err := p.taggedIndex(root, f, ft, prefix, path)
+			if err != nil {
+				return nil, err
+			}
+			indexes = append(indexes, idx)
+			continue
+		}
+
+		nested, ok := p.structFields(deref(f.typ))
+		nestedName := typeName(f.typ)
+		if !ok || visited[nestedName] {
+			continue
+		}
+
+		nestedPrefix := prefix
+		if !f.embedded {
+			nestedPrefix = path
+		}
+
+		visited[nestedName] = true
+		nestedIndexes, err := p.indexes(root, nested, path, nestedPrefix, visited)
+		if err != nil {
+			return nil, err
+		}
+		delete(visited, nestedName)
+		indexes = append(indexes, nestedIndexes...)
+	}
+	return indexes, nil
+}
+
+func (p *pkg) taggedIndex(root string, f field, ft fieldtag.Tag, prefix, path []string) (index, error) {
+	name := ft.Name
+	if name == "" {
+		name = f.name
+	}
+	name = strings.Join(append(append([]string{}, prefix...), name), ".")
+
+	if ft.Path != "" {
+		path = strings.Split(ft.Path, ".")
+	}
+
+	accessor, err := p.accessor(root, path)
+	if err != nil {
+		return index{}, fmt.Errorf("invalid index path for %s.%s: %s", root, f.name, err)
+	}
+
+	return index{
+		name:       name,
+		accessor:   accessor,
+		unique:     ft.Unique,
+		omitZero:   ft.OmitZero,
+		ignoreZero: ft.IgnoreZero,
+		desc:       ft.Desc,
+		collate:    ft.Collate,
+	}, nil
+}
+
+// accessor returns the code returning the value at path from r, a non nil pointer to root, or nil if a pointer
+// or map along the path is nil or doesn't hold the value
+func (p *pkg) accessor(root string, path []string) (string, error) {
+	var code bytes.Buffer
+	var typ ast.Expr = ast.NewIdent(root)
+	cur := "r"
+	n := 0
+
+	next := func(expr string, t ast.Expr, check bool) {
+		v := fmt.Sprintf("v%d", n)
+		n++
+		if check {
+			fmt.Fprintf(&code, "%s, ok := %s\nif !ok {\nreturn nil\n}\n", v, expr)
+		} else {
+			fmt.Fprintf(&code, "%s := %s\n", v, expr)
+		}
+		cur = v
+		typ = t
+	}
+
+	for i, step := range path {
+		if _, ok := typ.(*ast.StarExpr); ok && i > 0 {
+			fmt.Fprintf(&code, "if %s == nil {\nreturn nil\n}\n", cur)
+		}
+		typ = deref(typ)
+
+		if m, ok := typ.(*ast.MapType); ok {
+			key, err := mapKey(m.Key, step)
+			if err != nil {
+				return "", err
+			}
+			next(fmt.Sprintf("%s[%s]", cur, key), m.Value, true)
+			continue
+		}
+
+		chain, ok := p.lookupField(typ, step, map[string]bool{})
+		if !ok {
+			return "", fmt.Errorf("%s has no field %s, or isn't declared in this package", exprString(typ), step)
+		}
+		for j, f := range chain {
+			if j > 0 {
+				if _, ok := typ.(*ast.StarExpr); ok {
+					fmt.Fprintf(&code, "if %s == nil {\nreturn nil\n}\n", cur)
+				}
+			}
+			next(cur+"."+f.name, f.typ, false)
+		}
+	}
+
+	fmt.Fprintf(&code, "return %s\n", cur)
+	return code.String(), nil
+}
+
+// mapKey returns the literal of a map key of type typ
+func mapKey(typ ast.Expr, step string) (string, error) {
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		return "", fmt.Errorf("unsupported map key type %s", exprString(typ))
+	}
+	switch ident.Name {
+	case "string":
+		return strconv.Quote(step), nil
+	case "int", "int8", "int16", "int32", "int64":
+		if _, err := strconv.ParseInt(step, 10, 64); err != nil {
+			return "", err
+		}
+		return step, nil
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		if _, err := strconv.ParseUint(step, 10, 64); err != nil {
+			return "", err
+		}
+		return step, nil
+	}
+	return "", fmt.Errorf("unsupported map key type %s", ident.Name)
+}
+
+// basicKind returns the RecordWriter method and conversion used to write typ, if it's a predeclared type or a
+// type of the package defined from one
+func (p *pkg) basicKind(typ ast.Expr) (string, string, bool) {
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		return "", "", false
+	}
+	if kind, ok := basicKinds[ident.Name]; ok && p.types[ident.Name] == nil {
+		return kind[0], kind[1], true
+	}
+	if underlying, ok := p.types[ident.Name]; ok {
+		if u, ok := underlying.(*ast.Ident); ok && u.Name != ident.Name {
+			return p.basicKind(u)
+		}
+	}
+	return "", "", false
+}
+
+func isByteSlice(typ ast.Expr) bool {
+	array, ok := typ.(*ast.ArrayType)
+	if !ok || array.Len != nil {
+		return false
+	}
+	elt, ok := array.Elt.(*ast.Ident)
+	return ok && (elt.Name == "byte" || elt.Name == "uint8")
+}
+
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	err := format.Node(&buf, token.NewFileSet(), expr)
+	if err != nil {
+		return fmt.Sprintf("%T", expr)
+	}
+	return buf.String()
+}
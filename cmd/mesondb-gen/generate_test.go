@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_generateSample(t *testing.T) {
+	dir := filepath.Join("internal", "sample")
+	src, err := generate(dir, []string{"FileInfo", "Account"}, "sample_mesondb.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden, err := os.ReadFile(filepath.Join(dir, "sample_mesondb.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, golden) {
+		t.Fatal("internal/sample/sample_mesondb.go is out of date, run go generate ./...")
+	}
+}
+
+func Test_generateErrors(t *testing.T) {
+	dir := filepath.Join("internal", "sample")
+	if _, err := generate(dir, []string{"Status"}, "sample_mesondb.go"); err == nil {
+		t.Fatal("expected an error for a non struct type")
+	}
+	if _, err := generate(dir, []string{"Missing"}, "sample_mesondb.go"); err == nil {
+		t.Fatal("expected an error for a missing type")
+	}
+}
@@ -0,0 +1,41 @@
+// Package sample declares the types used to test the code generated by mesondb-gen against the reflection
+// based storer
+package sample
+
+//go:generate go run github.com/daqnext/meson-bolt-localdb/cmd/mesondb-gen -type=FileInfo,Account -output=sample_mesondb.go
+
+type Pointer struct {
+	Name string `boltholdIndex:"Name"`
+}
+
+type Status int
+
+type Base struct {
+	Group string `boltholdIndex:"Group"`
+}
+
+type FileInfo struct {
+	*Base
+	HashKey        string `boltholdKey:"HashKey"`
+	BindName       string `boltholdIndex:"BindName"`
+	LastAccessTime int64  `boltholdIndex:"LastAccessTime,desc"`
+	FileSize       int64
+	Rate           float64 `boltholdIndex:"Rate"`
+	Status         Status  `boltholdIndex:"Status"`
+	Shared         bool
+	Tags           []string
+	Data           []byte
+	P              *Pointer
+	Labels         map[string]string `boltholdIndex:"Env,path=Labels.env"`
+	ExternalID     string            `boltholdUnique:"ExternalID,ignorezero"`
+
+	internal string
+}
+
+type Account struct {
+	_     struct{} `boltholdBucket:"accounts"`
+	ID    uint64   `boltholdKey:"ID"`
+	Email string   `boltholdUnique:"Email"`
+	Owner Pointer  `boltholdIndex:"OwnerName,path=Owner.Name,omitzero"`
+	Nick  string   `mesondb:"index,collate=nocase"`
+}
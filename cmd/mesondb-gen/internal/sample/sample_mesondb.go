@@ -0,0 +1,240 @@
+// Code generated by mesondb-gen. DO NOT EDIT.
+
+package sample
+
+import (
+	mesondb "github.com/daqnext/meson-bolt-localdb"
+)
+
+// Indexes returns the indexes of FileInfo
+func (r FileInfo) Indexes() map[string]mesondb.Index {
+	return fileInfoIndexes
+}
+
+var fileInfoIndexes = map[string]mesondb.Index{
+	"BindName": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.BindName
+			return v0
+		},
+	},
+	"Env": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Labels
+			v1, ok := v0["env"]
+			if !ok {
+				return nil
+			}
+			return v1
+		},
+	},
+	"ExternalID": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.ExternalID
+			return v0
+		},
+		Unique:     true,
+		IgnoreZero: true,
+	},
+	"Group": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Base
+			if v0 == nil {
+				return nil
+			}
+			v1 := v0.Group
+			return v1
+		},
+	},
+	"LastAccessTime": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.LastAccessTime
+			return v0
+		},
+		Desc: true,
+	},
+	"P.Name": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.P
+			if v0 == nil {
+				return nil
+			}
+			v1 := v0.Name
+			return v1
+		},
+	},
+	"Rate": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Rate
+			return v0
+		},
+	},
+	"Status": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asFileInfo(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Status
+			return v0
+		},
+	},
+}
+
+func asFileInfo(value interface{}) *FileInfo {
+	switch v := value.(type) {
+	case *FileInfo:
+		return v
+	case FileInfo:
+		return &v
+	}
+	return nil
+}
+
+// BoltholdKey returns a pointer to the key field of FileInfo
+func (r *FileInfo) BoltholdKey() interface{} {
+	return &r.HashKey
+}
+
+// MarshalMesonDB encodes FileInfo without reflection
+func (r FileInfo) MarshalMesonDB() ([]byte, error) {
+	w := mesondb.NewRecordWriter(224)
+	w.Value(r.Base)
+	w.String(r.HashKey)
+	w.String(r.BindName)
+	w.Int(r.LastAccessTime)
+	w.Int(r.FileSize)
+	w.Float64(r.Rate)
+	w.Int(int64(r.Status))
+	w.Bool(r.Shared)
+	w.Value(r.Tags)
+	w.Bytes(r.Data)
+	w.Value(r.P)
+	w.Value(r.Labels)
+	w.String(r.ExternalID)
+	return w.Result()
+}
+
+// UnmarshalMesonDB decodes FileInfo without reflection
+func (r *FileInfo) UnmarshalMesonDB(data []byte) error {
+	d := mesondb.NewRecordReader(data)
+	d.Value(&r.Base)
+	r.HashKey = d.String()
+	r.BindName = d.String()
+	r.LastAccessTime = d.Int()
+	r.FileSize = d.Int()
+	r.Rate = d.Float64()
+	r.Status = Status(d.Int())
+	r.Shared = d.Bool()
+	d.Value(&r.Tags)
+	r.Data = d.Bytes()
+	d.Value(&r.P)
+	d.Value(&r.Labels)
+	r.ExternalID = d.String()
+	return d.Err()
+}
+
+// Indexes returns the indexes of Account
+func (r Account) Indexes() map[string]mesondb.Index {
+	return accountIndexes
+}
+
+var accountIndexes = map[string]mesondb.Index{
+	"Email": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asAccount(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Email
+			return v0
+		},
+		Unique: true,
+	},
+	"Nick": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asAccount(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Nick
+			return v0
+		},
+		Collate: "nocase",
+	},
+	"OwnerName": {
+		ValueFunc: func(value interface{}) interface{} {
+			r := asAccount(value)
+			if r == nil {
+				return nil
+			}
+			v0 := r.Owner
+			v1 := v0.Name
+			return v1
+		},
+		OmitZero: true,
+	},
+}
+
+func asAccount(value interface{}) *Account {
+	switch v := value.(type) {
+	case *Account:
+		return v
+	case Account:
+		return &v
+	}
+	return nil
+}
+
+// BoltholdKey returns a pointer to the key field of Account
+func (r *Account) BoltholdKey() interface{} {
+	return &r.ID
+}
+
+// MarshalMesonDB encodes Account without reflection
+func (r Account) MarshalMesonDB() ([]byte, error) {
+	w := mesondb.NewRecordWriter(80)
+	w.Uint(r.ID)
+	w.String(r.Email)
+	w.Value(r.Owner)
+	w.String(r.Nick)
+	return w.Result()
+}
+
+// UnmarshalMesonDB decodes Account without reflection
+func (r *Account) UnmarshalMesonDB(data []byte) error {
+	d := mesondb.NewRecordReader(data)
+	r.ID = d.Uint()
+	r.Email = d.String()
+	d.Value(&r.Owner)
+	r.Nick = d.String()
+	return d.Err()
+}
@@ -0,0 +1,344 @@
+package sample
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	mesondb "github.com/daqnext/meson-bolt-localdb"
+	bolt "go.etcd.io/bbolt"
+)
+
+// plainFileInfo and plainAccount have the same fields and tags as the generated types, but none of their
+// methods, so the store uses reflection for them
+type plainFileInfo FileInfo
+
+type plainAccount Account
+
+func sampleFiles() []FileInfo {
+	var files []FileInfo
+	for i := 0; i < 20; i++ {
+		f := FileInfo{
+			BindName:       fmt.Sprintf("bind-%d", i%4),
+			LastAccessTime: int64(i*7%13 - 6),
+			FileSize:       int64(i * 100),
+			Rate:           float64(i)*0.33 - 3,
+			Status:         Status(i % 3),
+			Shared:         i%2 == 0,
+			Tags:           []string{"a", fmt.Sprint(i)},
+			Data:           []byte{byte(i)},
+		}
+		if i%3 == 0 {
+			f.Base = &Base{Group: fmt.Sprintf("group-%d", i%2)}
+		}
+		if i%4 != 0 {
+			f.P = &Pointer{Name: fmt.Sprintf("p-%d", i%5)}
+		}
+		if i%5 != 0 {
+			f.Labels = map[string]string{"env": fmt.Sprintf("env-%d", i%2)}
+		}
+		if i%2 == 0 {
+			f.ExternalID = fmt.Sprintf("ext-%d", i)
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// bucketContent returns the content of all buckets whose name starts with prefix, by name without the prefix
+func bucketContent(t *testing.T, store *mesondb.Store, prefix string) map[string][][2][]byte {
+	content := make(map[string][][2][]byte)
+	err := store.Bolt().View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !strings.HasPrefix(string(name), prefix) {
+				return nil
+			}
+			var kvs [][2][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				kvs = append(kvs, [2][]byte{k, v})
+				return nil
+			})
+			content[strings.TrimPrefix(string(name), prefix)] = kvs
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// gobEncode returns the encoding of value by the reflection path, gob
+func gobEncode(t *testing.T, value interface{}) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sameContent fails unless the buckets have the same keys and values
+func sameContent(t *testing.T, name string, generated, reflected [][2][]byte) {
+	t.Helper()
+	if len(generated) != len(reflected) {
+		t.Fatalf("%s has %d values, expected %d", name, len(generated), len(reflected))
+	}
+	for i := range reflected {
+		if !bytes.Equal(generated[i][0], reflected[i][0]) {
+			t.Fatalf("%s differs at key %d: %q, expected %q", name, i, generated[i][0], reflected[i][0])
+		}
+		if !bytes.Equal(generated[i][1], reflected[i][1]) {
+			t.Fatalf("%s differs at the value of key %q", name, reflected[i][0])
+		}
+	}
+}
+
+func Test_generatedIndexesMatchReflection(t *testing.T) {
+	store, err := mesondb.Open(filepath.Join(t.TempDir(), "test.db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// plainAccount has the boltholdBucket tag of Account
+	if err := store.Register(plainAccount{}, "plainAccount"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := sampleFiles()
+	for i, f := range files {
+		key := fmt.Sprintf("%02d", i)
+		if err := store.Insert(key, f); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Insert(key, plainFileInfo(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(1); i <= 5; i++ {
+		a := Account{Email: fmt.Sprintf("a%d@example.com", i)}
+		if i%2 == 0 {
+			a.Owner.Name = fmt.Sprintf("owner-%d", i)
+			a.Nick = "Nick"
+		} else {
+			a.Nick = "NICK"
+		}
+		if err := store.Insert(i, &a); err != nil {
+			t.Fatal(err)
+		}
+		if a.ID != i {
+			t.Fatalf("expected the generated key accessor to set ID %d, got %d", i, a.ID)
+		}
+		if err := store.Insert(i, plainAccount(a)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var accounts []Account
+	if err := store.Find(&accounts, mesondb.NewQuery("Nick").Equal("nick")); err != nil || len(accounts) != 5 {
+		t.Fatalf("expected the nocase index to match 5 accounts, got %d (%v)", len(accounts), err)
+	}
+
+	// the records are stored by the reflection path, under the same keys
+	records := bucketContent(t, store, "FileInfo")[""]
+	plainRecords := bucketContent(t, store, "plainFileInfo")[""]
+	if len(records) != len(files) || len(plainRecords) != len(files) {
+		t.Fatalf("expected %d records, got %d generated and %d reflected", len(files), len(records),
+			len(plainRecords))
+	}
+	for i, f := range files {
+		if !bytes.Equal(records[i][0], plainRecords[i][0]) {
+			t.Fatalf("record %d is stored under %q, expected %q", i, records[i][0], plainRecords[i][0])
+		}
+		if !bytes.Equal(records[i][1], gobEncode(t, f)) {
+			t.Fatalf("record %d isn't stored with gob", i)
+		}
+		if !bytes.Equal(plainRecords[i][1], gobEncode(t, plainFileInfo(f))) {
+			t.Fatalf("reflected record %d isn't stored with gob", i)
+		}
+	}
+	accountRecords := bucketContent(t, store, "accounts")[""]
+	plainAccountRecords := bucketContent(t, store, "plainAccount")[""]
+	if len(accountRecords) != 5 || len(plainAccountRecords) != 5 {
+		t.Fatalf("expected 5 accounts, got %d generated and %d reflected", len(accountRecords),
+			len(plainAccountRecords))
+	}
+	for i := range accountRecords {
+		if !bytes.Equal(accountRecords[i][0], plainAccountRecords[i][0]) {
+			t.Fatalf("account %d is stored under %q, expected %q", i, accountRecords[i][0],
+				plainAccountRecords[i][0])
+		}
+	}
+
+	for _, types := range [][2]string{{"FileInfo", "plainFileInfo"}, {"accounts", "plainAccount"}} {
+		generated := bucketContent(t, store, "_index:"+types[0]+":")
+		reflected := bucketContent(t, store, "_index:"+types[1]+":")
+
+		var names []string
+		for name := range reflected {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 || len(generated) != len(reflected) {
+			t.Fatalf("%s: expected the same indexes, got %d generated and %d reflected", types[0],
+				len(generated), len(reflected))
+		}
+
+		for _, name := range names {
+			sameContent(t, types[0]+" index "+name, generated[name], reflected[name])
+		}
+	}
+}
+
+func Test_generatedTypeNames(t *testing.T) {
+	store, err := mesondb.Open(filepath.Join(t.TempDir(), "test.db"), 0666,
+		&mesondb.Options{QualifiedTypeNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Register(Account{}, "members"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("a", FileInfo{BindName: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert(uint64(1), Account{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	qualified := reflect.TypeOf(FileInfo{}).PkgPath() + ".FileInfo"
+	for _, name := range []string{qualified, "_index:" + qualified + ":BindName", "members", "_index:members:Email"} {
+		if len(bucketContent(t, store, name)) == 0 {
+			t.Fatalf("bucket %s doesn't exist", name)
+		}
+	}
+	if err := store.Register(FileInfo{}, "files"); !errors.Is(err, mesondb.ErrTypeConflict) {
+		t.Fatalf("expected the generated type to be registered like the others, got %v", err)
+	}
+}
+
+func Test_generatedCodec(t *testing.T) {
+	store, err := mesondb.Open(filepath.Join(t.TempDir(), "test.db"), 0666,
+		&mesondb.Options{GeneratedCodec: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	files := sampleFiles()
+	for i, f := range files {
+		if err := store.Insert(fmt.Sprintf("%02d", i), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := bucketContent(t, store, "FileInfo")[""]
+	for i, f := range files {
+		f.HashKey = fmt.Sprintf("%02d", i)
+		var got FileInfo
+		if err := store.Get(f.HashKey, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, f) {
+			t.Fatalf("record %d doesn't round trip:\n%+v\n%+v", i, got, f)
+		}
+
+		encoded, err := files[i].MarshalMesonDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(records[i][1], append([]byte{0}, encoded...)) {
+			t.Fatalf("record %d isn't stored with the generated codec", i)
+		}
+	}
+
+	var found []FileInfo
+	q := mesondb.NewQuery("P.Name").Equal("p-1")
+	if err := store.Find(&found, q); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) == 0 {
+		t.Fatal("expected records")
+	}
+	for _, f := range found {
+		if f.P == nil || f.P.Name != "p-1" || f.HashKey == "" {
+			t.Fatalf("unexpected record %+v", f)
+		}
+	}
+
+	encoded := records[1][1]
+	var decoded *FileInfo
+	if err := mesondb.DefaultDecode(encoded, &decoded); err != nil || !reflect.DeepEqual(*decoded, files[1]) {
+		t.Fatalf("expected decoding into a pointer to pointer to use the codec, got %v", err)
+	}
+	if err := mesondb.DefaultDecode(encoded[:len(encoded)-1], &FileInfo{}); err == nil {
+		t.Fatal("expected an error decoding a truncated record")
+	}
+}
+
+func Test_generatedCodecReadsGob(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	open := func(options *mesondb.Options) *mesondb.Store {
+		store, err := mesondb.Open(filename, 0666, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	check := func(store *mesondb.Store, files []FileInfo) {
+		t.Helper()
+		var found []FileInfo
+		if err := store.Find(&found, mesondb.NewQuery(mesondb.Key).Range()); err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != len(files) {
+			t.Fatalf("expected %d records, got %d", len(files), len(found))
+		}
+		for i, f := range files {
+			f.HashKey = fmt.Sprintf("%02d", i)
+			if !reflect.DeepEqual(found[i], f) {
+				t.Fatalf("record %d isn't read back:\n%+v\n%+v", i, found[i], f)
+			}
+		}
+	}
+
+	files := sampleFiles()
+	store := open(nil)
+	for i, f := range files {
+		if err := store.Insert(fmt.Sprintf("%02d", i), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records stored with gob are read through the generated codec, and rewritten with it
+	store = open(&mesondb.Options{GeneratedCodec: true})
+	check(store, files)
+	files[3].BindName = "updated"
+	if err := store.Update("03", files[3]); err != nil {
+		t.Fatal(err)
+	}
+	records := bucketContent(t, store, "FileInfo")[""]
+	if records[3][1][0] != 0 || !bytes.Equal(records[2][1], gobEncode(t, files[2])) {
+		t.Fatal("expected only the updated record to be stored with the generated codec")
+	}
+	check(store, files)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// and the store still reads both without the generated codec
+	store = open(nil)
+	defer store.Close()
+	check(store, files)
+}
@@ -0,0 +1,55 @@
+// Command mesondb-gen generates Indexer, KeyAccessor and binary codec implementations for structs stored in a
+// meson-bolt-localdb store, so the store doesn't need reflection to index, key and encode their records.
+//
+// It reads the mesondb, boltholdKey, boltholdIndex and boltholdUnique tags of the given types, and is meant to
+// be run by go generate in the package declaring them:
+//
+//	//go:generate go run github.com/daqnext/meson-bolt-localdb/cmd/mesondb-gen -type=FileInfo
+//
+// The generated indexes return the same values as the reflection based indexes, so they are encoded to the same
+// bytes by the store's encoder, and the types are stored in the same buckets.  The generated codec is only used
+// by stores opened with Options.GeneratedCodec, records already stored with gob are still decoded with gob.
+// Only structs declared in the generated package are walked for nested tags and index paths, fields with types
+// from other packages are encoded with gob.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma separated list of type names, required")
+	output := flag.String("output", "", "output file name, default <type>_mesondb.go")
+	flag.Parse()
+
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	types := strings.Split(*typeNames, ",")
+	if *output == "" {
+		*output = strings.ToLower(types[0]) + "_mesondb.go"
+	}
+
+	src, err := generate(dir, types, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mesondb-gen:", err)
+		os.Exit(1)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, *output), src, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mesondb-gen:", err)
+		os.Exit(1)
+	}
+}
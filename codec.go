@@ -0,0 +1,299 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"math"
+	"reflect"
+)
+
+// Marshaler can be implemented by a record to replace the encoding of the store when Options.GeneratedCodec is
+// set.  It's implemented by the code generated with cmd/mesondb-gen
+type Marshaler interface {
+	MarshalMesonDB() ([]byte, error)
+}
+
+// Unmarshaler can be implemented by a pointer to a record to decode the records encoded by its Marshaler.
+// It's implemented by the code generated with cmd/mesondb-gen
+type Unmarshaler interface {
+	UnmarshalMesonDB(data []byte) error
+}
+
+// codecFormat prefixes the records encoded by a Marshaler.  A gob stream starts with the length of its first
+// message, which is never zero, so records stored with gob before the codec was used are still decoded with gob
+const codecFormat byte = 0
+
+// codecEncode returns an EncodeFunc encoding Marshalers with their codec, and any other value with encode
+func codecEncode(encode EncodeFunc) EncodeFunc {
+	return func(value interface{}) ([]byte, error) {
+		m, ok := value.(Marshaler)
+		if !ok {
+			return encode(value)
+		}
+		data, err := m.MarshalMesonDB()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{codecFormat}, data...), nil
+	}
+}
+
+// codecDecode returns a DecodeFunc decoding the records encoded by a Marshaler with their codec, and any other
+// data with decode
+func codecDecode(decode DecodeFunc) DecodeFunc {
+	return func(data []byte, value interface{}) error {
+		if u, ok := codecUnmarshaler(data, value); ok {
+			return u.UnmarshalMesonDB(data[1:])
+		}
+		return decode(data, value)
+	}
+}
+
+// codecUnmarshaler returns the Unmarshaler of value if data was encoded by a Marshaler
+func codecUnmarshaler(data []byte, value interface{}) (Unmarshaler, bool) {
+	if len(data) == 0 || data[0] != codecFormat {
+		return nil, false
+	}
+	return unmarshaler(value)
+}
+
+// KeyAccessor can be implemented by a pointer to a record to access its key field without reflection, instead of
+// the boltholdKey tag.  It's implemented by the code generated with cmd/mesondb-gen
+type KeyAccessor interface {
+	BoltholdKey() interface{} // pointer to the key field
+}
+
+var errShortRecord = errors.New("record data is too short")
+
+// unmarshaler returns the Unmarshaler of value, following (and allocating) one level of pointer so decoding into
+// a **Record works like gob does
+func unmarshaler(value interface{}) (Unmarshaler, bool) {
+	if u, ok := value.(Unmarshaler); ok {
+		return u, true
+	}
+
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Ptr {
+		return nil, false
+	}
+	if !val.Elem().Type().Implements(reflect.TypeOf((*Unmarshaler)(nil)).Elem()) {
+		return nil, false
+	}
+	if val.Elem().IsNil() {
+		val.Elem().Set(reflect.New(val.Elem().Type().Elem()))
+	}
+	return val.Elem().Interface().(Unmarshaler), true
+}
+
+// RecordWriter writes the fields of a record in the binary format of generated codecs
+type RecordWriter struct {
+	buf []byte
+	err error
+}
+
+// NewRecordWriter returns a RecordWriter with size bytes preallocated
+func NewRecordWriter(size int) *RecordWriter {
+	return &RecordWriter{buf: make([]byte, 0, size)}
+}
+
+// String writes a length prefixed string
+func (w *RecordWriter) String(v string) {
+	w.Uint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// Bytes writes a length prefixed byte slice
+func (w *RecordWriter) Bytes(v []byte) {
+	w.Uint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// Bool writes a bool as one byte
+func (w *RecordWriter) Bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// Int writes a signed integer as a varint
+func (w *RecordWriter) Int(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	w.buf = append(w.buf, b[:n]...)
+}
+
+// Uint writes an unsigned integer as a uvarint
+func (w *RecordWriter) Uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf = append(w.buf, b[:n]...)
+}
+
+// Float32 writes the bits of a float32
+func (w *RecordWriter) Float32(v float32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+// Float64 writes the bits of a float64
+func (w *RecordWriter) Float64(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+// Value writes any other value with gob, nil pointers, maps, slices and interfaces are written as absent
+func (w *RecordWriter) Value(v interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Invalid:
+		w.Bool(false)
+		return
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		if val.IsNil() {
+			w.Bool(false)
+			return
+		}
+	}
+
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(v)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.Bool(true)
+	w.Bytes(buff.Bytes())
+}
+
+// Result returns the written record, or the first error met by Value
+func (w *RecordWriter) Result() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// RecordReader reads the fields of a record written by a RecordWriter.  Once an error is met, all reads return
+// zero values and Err returns the error
+type RecordReader struct {
+	data []byte
+	err  error
+}
+
+// NewRecordReader returns a RecordReader reading data
+func NewRecordReader(data []byte) *RecordReader {
+	return &RecordReader{data: data}
+}
+
+func (r *RecordReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errShortRecord
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// String reads a length prefixed string
+func (r *RecordReader) String() string {
+	return string(r.next(int(r.Uint())))
+}
+
+// Bytes reads a length prefixed byte slice
+func (r *RecordReader) Bytes() []byte {
+	n := int(r.Uint())
+	if n == 0 {
+		return nil
+	}
+	return append([]byte(nil), r.next(n)...)
+}
+
+// Bool reads a bool
+func (r *RecordReader) Bool() bool {
+	b := r.next(1)
+	return b != nil && b[0] != 0
+}
+
+// Int reads a varint
+func (r *RecordReader) Int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errShortRecord
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// Uint reads a uvarint
+func (r *RecordReader) Uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errShortRecord
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// Float32 reads a float32
+func (r *RecordReader) Float32() float32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+// Float64 reads a float64
+func (r *RecordReader) Float64() float64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// Value reads a value written by RecordWriter.Value into ptr, an absent value sets it to its zero value
+func (r *RecordReader) Value(ptr interface{}) {
+	if !r.Bool() {
+		if r.err == nil {
+			val := reflect.ValueOf(ptr).Elem()
+			val.Set(reflect.Zero(val.Type()))
+		}
+		return
+	}
+	data := r.next(int(r.Uint()))
+	if r.err != nil {
+		return
+	}
+	r.err = gob.NewDecoder(bytes.NewReader(data)).Decode(ptr)
+}
+
+// Err returns the first error met while reading, or an error if the data wasn't read entirely
+func (r *RecordReader) Err() error {
+	if r.err == nil && len(r.data) > 0 {
+		return errors.New("record data has unread bytes")
+	}
+	return r.err
+}
@@ -0,0 +1,101 @@
+package meson_bolt_localdb
+
+import (
+	"context"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ctxErr returns the error of ctx once it's done, nil without a context
+func ctxErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// withContext returns a copy of query and its sub-queries stopping with the error of ctx once it's done
+func withContext(ctx context.Context, query *Query) *Query {
+	if query == nil {
+		return &Query{ctx: ctx}
+	}
+	bound := *query
+	bound.ctx = ctx
+	bound.and = make([]*Query, len(query.and))
+	for i, and := range query.and {
+		bound.and[i] = withContext(ctx, and)
+	}
+	bound.or = make([]*Query, len(query.or))
+	for i, or := range query.or {
+		bound.or[i] = withContext(ctx, or)
+	}
+	return &bound
+}
+
+// FindCtx is Find, stopping with the error of ctx as soon as it's done while the index and the records are read
+func (s *Store) FindCtx(ctx context.Context, result interface{}, query *Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.findQuery(tx, result, withContext(ctx, query))
+	})
+}
+
+// FindOneCtx is FindOne, stopping with the error of ctx as soon as it's done
+func (s *Store) FindOneCtx(ctx context.Context, result interface{}, query *Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.findOneQuery(tx, result, withContext(ctx, query))
+	})
+}
+
+// CountCtx is Count, stopping with the error of ctx as soon as it's done
+func (s *Store) CountCtx(ctx context.Context, dataType interface{}, query *Query) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	count := 0
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var err error
+		count, err = s.countQuery(tx, dataType, withContext(ctx, query))
+		return err
+	})
+	return count, err
+}
+
+// ForEachCtx is ForEach, stopping with the error of ctx as soon as it's done
+func (s *Store) ForEachCtx(ctx context.Context, dataType interface{}, query *Query,
+	fn func(key []byte, record interface{}) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.forEach(tx, dataType, withContext(ctx, query), fn)
+	})
+}
+
+// UpdateMatchingCtx is UpdateMatching, stopping with the error of ctx as soon as it's done.  The transaction is
+// then rolled back, so no record is updated
+func (s *Store) UpdateMatchingCtx(ctx context.Context, dataType interface{}, query *Query,
+	update func(record interface{}) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Bolt().Update(func(tx *bolt.Tx) error {
+		return s.updateQuery(tx, dataType, withContext(ctx, query), update)
+	})
+}
+
+// DeleteMatchingCtx is DeleteMatching, stopping with the error of ctx as soon as it's done.  The transaction is
+// then rolled back, so no record is deleted
+func (s *Store) DeleteMatchingCtx(ctx context.Context, dataType interface{}, query *Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Bolt().Update(func(tx *bolt.Tx) error {
+		return s.deleteQuery(tx, dataType, withContext(ctx, query))
+	})
+}
@@ -0,0 +1,97 @@
+package meson_bolt_localdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_queryContext(t *testing.T) {
+	store := iterStore(t)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	for _, ctx := range []context.Context{canceled, expired} {
+		if err := store.FindCtx(ctx, &[]orderedRecord{}, nil); err != ctx.Err() {
+			t.Fatalf("expected %v from FindCtx, got %v", ctx.Err(), err)
+		}
+		if err := store.FindOneCtx(ctx, &orderedRecord{}, nil); err != ctx.Err() {
+			t.Fatalf("expected %v from FindOneCtx, got %v", ctx.Err(), err)
+		}
+		if _, err := store.CountCtx(ctx, &orderedRecord{}, nil); err != ctx.Err() {
+			t.Fatalf("expected %v from CountCtx, got %v", ctx.Err(), err)
+		}
+		if err := store.DeleteMatchingCtx(ctx, &orderedRecord{}, nil); err != ctx.Err() {
+			t.Fatalf("expected %v from DeleteMatchingCtx, got %v", ctx.Err(), err)
+		}
+	}
+
+	// the context is done while the records are walked
+	queries := []*Query{
+		NewQuery(Key).Range(),
+		NewQuery("Group").Equal("a"),
+		NewQuery("FileSize").In(int64(100), int64(200)).Or(NewQuery("Group").Equal("b")),
+		NewQuery("Age").Range().OrderBy("Name"),
+		NewQuery("Group").Range().And(NewQuery("FileSize").Range(Condition(OpGe, 0))),
+	}
+	for i, query := range queries {
+		ctx, cancel := context.WithCancel(context.Background())
+		n := 0
+		err := store.ForEachCtx(ctx, &orderedRecord{}, query, func(key []byte, record interface{}) error {
+			n++
+			cancel()
+			return nil
+		})
+		if err != context.Canceled || n != 1 {
+			t.Fatalf("query %d: expected context.Canceled after 1 record, got %v after %d", i, err, n)
+		}
+	}
+
+	// the transaction is rolled back
+	ctx, cancel := context.WithCancel(context.Background())
+	err := store.UpdateMatchingCtx(ctx, &orderedRecord{}, nil, func(record interface{}) error {
+		record.(*orderedRecord).Name = "updated"
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled from UpdateMatchingCtx, got %v", err)
+	}
+	if count, err := store.Count(&orderedRecord{}, NewQuery(Key).Where("Name", OpEq, "updated")); err != nil ||
+		count != 0 {
+		t.Fatalf("expected no updated record, got %d (%v)", count, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	query := NewQuery(Key).Filter(func(record interface{}) bool {
+		if record.(*orderedRecord).ID == 5 {
+			cancel()
+		}
+		return true
+	})
+	if err := store.DeleteMatchingCtx(ctx, &orderedRecord{}, query); err != context.Canceled {
+		t.Fatalf("expected context.Canceled from DeleteMatchingCtx, got %v", err)
+	}
+	if count, err := store.Count(&orderedRecord{}, nil); err != nil || count != 10 {
+		t.Fatalf("expected 10 records left, got %d (%v)", count, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var records []orderedRecord
+	if err := store.FindCtx(ctx, &records, NewQuery("Group").Equal("b")); err != nil || len(records) != 5 {
+		t.Fatalf("expected 5 records, got %d (%v)", len(records), err)
+	}
+	if count, err := store.CountCtx(ctx, &orderedRecord{}, NewQuery("Group").Equal("a")); err != nil || count != 5 {
+		t.Fatalf("expected a count of 5, got %d (%v)", count, err)
+	}
+	if err := store.DeleteMatchingCtx(ctx, &orderedRecord{}, NewQuery("Group").Equal("a")); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := store.Count(&orderedRecord{}, nil); err != nil || count != 5 {
+		t.Fatalf("expected 5 records left, got %d (%v)", count, err)
+	}
+}
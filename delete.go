@@ -29,7 +29,10 @@ func (s *Store) TxDelete(tx *bolt.Tx, key, dataType interface{}) error {
 //}
 
 func (s *Store) delete(source BucketSource, key, dataType interface{}) error {
-	storer := s.newStorer(dataType)
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
 	gk, err := s.encode(key)
 
 	if err != nil {
@@ -155,6 +155,11 @@ func DefaultDecode(data []byte, value interface{}) error {
 		return nil
 
 	default:
+		// gob data never starts with the format byte of the generated codecs
+		if u, ok := codecUnmarshaler(data, value); ok {
+			return u.UnmarshalMesonDB(data[1:])
+		}
+
 		var buff bytes.Buffer
 		de := gob.NewDecoder(&buff)
 
@@ -166,9 +171,6 @@ func DefaultDecode(data []byte, value interface{}) error {
 		return de.Decode(value)
 
 	}
-
-	return nil
-
 }
 
 func Int64ToBytes(i int64) []byte {
@@ -0,0 +1,424 @@
+package meson_bolt_localdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// queryStats counts the work done by a query run by Explain
+type queryStats struct {
+	indexEntries   int
+	keysVisited    int
+	recordsDecoded int
+}
+
+// add adds to the counters, stats is nil unless the query is run by Explain
+func (st *queryStats) add(indexEntries, keysVisited, recordsDecoded int) {
+	if st == nil {
+		return
+	}
+	st.indexEntries += indexEntries
+	st.keysVisited += keysVisited
+	st.recordsDecoded += recordsDecoded
+}
+
+// Bound is a bound of the index values walked by a Range query
+type Bound struct {
+	Value     interface{}
+	Inclusive bool
+}
+
+// Plan describes how a query is run, it's returned by Explain
+type Plan struct {
+	// Type is the bucket of the records
+	Type string
+	// Scan is how the records are found: "range", "equal" or "in" on Bucket, "union" of the Or plans or "sort"
+	// of the records found by the Input plan
+	Scan string
+	// Index is the index of the query, empty for the Key
+	Index string
+	// Bucket is the walked bucket, the bucket of the records for the Key or an index bucket
+	Bucket string
+	// Backward is set when the bucket is walked from its last key
+	Backward bool
+	// Lower and Upper are the bounds of a range scan, nil when unbounded
+	Lower, Upper *Bound
+	// Values are the values sought by an equal or in scan
+	Values []interface{}
+	// Excluded is the number of index values skipped by NotIn and ExcludeValues
+	Excluded int
+	// Filters are the checks of each key found: "exclude keys", "expiry", "after", "and", "where ..." and "filter"
+	Filters []string
+	// And are the plans of the And queries, whose keys are intersected before the walk from the query finding the
+	// fewest keys
+	And []*Plan
+	// Or are the plans of the queries whose records are merged by a union scan
+	Or []*Plan
+	// Input is the plan finding the records of a sort scan
+	Input *Plan
+	// Sort are the fields of a sort scan, and SortBy how it's done: walking an index, keeping the first records
+	// in a heap, or sorting all the records in memory
+	Sort   []string
+	SortBy string
+	Offset int
+	Limit  int
+	// OffsetWalked is set when the skipped records are walked to apply the offset, which After avoids
+	OffsetWalked bool
+	// Estimated is the number of rows estimated from the number of records and of index values, each counted up
+	// to 10000
+	Estimated int
+
+	// the work done running the query, sub-queries included, only set on the plan returned by Explain
+	IndexEntries   int // entries read from the walked buckets
+	KeysVisited    int // record keys checked against the filters and the offset
+	RecordsDecoded int // records decoded to filter or sort them
+	Rows           int // rows matching the query
+}
+
+// Explain runs query over the records of dataType and returns its plan, with the work done and the number of
+// matching rows.  The matching records themselves aren't decoded
+func (s *Store) Explain(dataType interface{}, query *Query) (*Plan, error) {
+	var plan *Plan
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var err error
+		plan, err = s.TxExplain(tx, dataType, query)
+		return err
+	})
+	return plan, err
+}
+
+// TxExplain allows you to pass in your own bolt transaction to explain a query
+func (s *Store) TxExplain(tx *bolt.Tx, dataType interface{}, query *Query) (*Plan, error) {
+	return s.explainQuery(tx, dataType, query)
+}
+
+func (s *Store) explainQuery(source BucketSource, dataType interface{}, query *Query) (*Plan, error) {
+	err := checkQuery(&query)
+	if err != nil {
+		return nil, err
+	}
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return nil, err
+	}
+	rType := recordType(dataType)
+
+	stats := &queryStats{}
+	query = withStats(query, stats)
+	plan := s.describeQuery(source, storer, rType, query)
+
+	if mainBkt := source.Bucket([]byte(storer.Type())); mainBkt != nil {
+		keys, _, err := s.queryKeys(source, storer, mainBkt, rType, query)
+		if err != nil {
+			return nil, err
+		}
+		plan.Rows = len(keys)
+	}
+	plan.IndexEntries = stats.indexEntries
+	plan.KeysVisited = stats.keysVisited
+	plan.RecordsDecoded = stats.recordsDecoded
+	return plan, nil
+}
+
+// withStats returns a copy of query and its sub-queries counting their work in stats
+func withStats(query *Query, stats *queryStats) *Query {
+	counted := *query
+	counted.stats = stats
+	counted.and = make([]*Query, len(query.and))
+	for i, and := range query.and {
+		counted.and[i] = withStats(and, stats)
+	}
+	counted.or = make([]*Query, len(query.or))
+	for i, or := range query.or {
+		counted.or[i] = withStats(or, stats)
+	}
+	return &counted
+}
+
+// bucketSampleSize is the number of keys counted at most by bucketSize
+const bucketSampleSize = 10000
+
+// bucketSize counts the keys of bkt with a cursor, up to bucketSampleSize, so the pages of large buckets aren't
+// all read to explain a query.  Larger buckets are estimated as holding bucketSampleSize keys
+func bucketSize(bkt *bolt.Bucket) int {
+	if bkt == nil {
+		return 0
+	}
+	n := 0
+	c := bkt.Cursor()
+	for k, _ := c.First(); k != nil && n < bucketSampleSize; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+// describeQuery returns the plan of query, estimating its rows from the bucket sizes: a range bound keeps
+// half of the records, and each index value holds the same number of records
+func (s *Store) describeQuery(source BucketSource, storer Storer, rType reflect.Type, query *Query) *Plan {
+	plan := &Plan{
+		Type:         storer.Type(),
+		Index:        query.index,
+		Offset:       query.offset,
+		Limit:        query.limit,
+		OffsetWalked: query.offset > 0,
+	}
+	records := bucketSize(source.Bucket([]byte(storer.Type())))
+
+	if len(query.orderBy) > 0 {
+		all := *query
+		all.limit = 0
+		all.offset = 0
+		all.orderBy = nil
+		plan.Scan = "sort"
+		plan.Input = s.describeQuery(source, storer, rType, &all)
+		for _, field := range query.orderBy {
+			name := field.field
+			if name == Key {
+				name = "Key"
+			}
+			if field.order == Desc {
+				name += " desc"
+			}
+			plan.Sort = append(plan.Sort, name)
+		}
+		switch indexName, _, ok := s.sortIndex(storer, rType, query.orderBy[0]); {
+		case ok:
+			plan.SortBy = "index " + indexName
+		case query.limit > 0:
+			plan.SortBy = "heap"
+		default:
+			plan.SortBy = "memory"
+		}
+		plan.Estimated = limitEstimate(plan.Input.Estimated, query)
+		return plan
+	}
+
+	if len(query.or) > 0 {
+		plan.Scan = "union"
+		plan.Backward = query.reverse
+		estimated := 0
+		for _, q := range append([]*Query{query}, query.or...) {
+			all := *q
+			all.limit = 0
+			all.offset = 0
+			all.or = nil
+			or := s.describeQuery(source, storer, rType, &all)
+			plan.Or = append(plan.Or, or)
+			estimated += or.Estimated
+		}
+		if estimated > records {
+			estimated = records
+		}
+		plan.Estimated = limitEstimate(estimated, query)
+		return plan
+	}
+
+	index, ok := storer.Indexes()[query.index]
+	plan.Backward = query.reverse != (ok && index.Desc)
+	distinct := records
+	if query.index == Key {
+		plan.Bucket = storer.Type()
+	} else {
+		plan.Bucket = string(indexBucketName(storer.Type(), query.index))
+		distinct = bucketSize(source.Bucket([]byte(plan.Bucket)))
+	}
+	perValue := 0
+	if distinct > 0 {
+		perValue = (records + distinct - 1) / distinct
+	}
+
+	estimated := records
+	switch query.queryType {
+	case QueryRange:
+		plan.Scan = "range"
+		lower, upper, empty := s.rangeBounds(storer, rType, query)
+		for _, b := range []*bound{lower, upper} {
+			if b != nil {
+				estimated /= 2
+			}
+		}
+		if lower != nil {
+			plan.Lower = &Bound{Value: lower.criterion.value, Inclusive: lower.inclusive}
+		}
+		if upper != nil {
+			plan.Upper = &Bound{Value: upper.criterion.value, Inclusive: upper.inclusive}
+		}
+		if empty {
+			estimated = 0
+		}
+	case QueryEqual:
+		plan.Scan = "equal"
+		plan.Values = []interface{}{query.equalCriteria.value}
+		estimated = perValue
+	case QueryIn:
+		plan.Scan = "in"
+		plan.Values = query.inCriteria
+		estimated = len(query.inCriteria) * perValue
+		if estimated > records {
+			estimated = records
+		}
+	}
+	plan.Excluded = len(query.notIn)
+
+	if len(query.excludeKeys) > 0 {
+		plan.Filters = append(plan.Filters, "exclude keys")
+	}
+	if storerExpiry(storer) != nil {
+		plan.Filters = append(plan.Filters, "expiry")
+	}
+	if query.after != nil {
+		plan.Filters = append(plan.Filters, "after")
+	}
+	if len(query.and) > 0 {
+		plan.Filters = append(plan.Filters, "and")
+		for _, q := range query.and {
+			all := *q
+			all.limit = 0
+			all.offset = 0
+			and := s.describeQuery(source, storer, rType, &all)
+			plan.And = append(plan.And, and)
+			if and.Estimated < estimated {
+				estimated = and.Estimated
+			}
+		}
+	}
+	for _, where := range query.where {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("where %s %s %v", where.field, operatorSymbol(where.op),
+			where.value))
+	}
+	if len(query.filters) > 0 {
+		plan.Filters = append(plan.Filters, "filter")
+	}
+
+	plan.Estimated = limitEstimate(estimated, query)
+	return plan
+}
+
+// rangeBounds returns the lower and upper bounds of the index values walked by a range query, and whether no
+// value is in between.  Invalid values are left for the query to report
+func (s *Store) rangeBounds(storer Storer, rType reflect.Type, query *Query) (*bound, *bound, bool) {
+	converted, err := s.convertQuery(storer, rType, query)
+	if err != nil {
+		return nil, nil, false
+	}
+	index, desc := storer.Indexes()[query.index]
+	desc = desc && index.Desc
+	if desc {
+		converted = descQuery(converted)
+	}
+	in, err := s.rangeInterval(storer, converted)
+	if err != nil {
+		return nil, nil, false
+	}
+	if desc {
+		// the bounds of the stored keys are the opposite bounds of the values
+		return in.upper, in.lower, in.empty()
+	}
+	return in.lower, in.upper, in.empty()
+}
+
+// limitEstimate applies the offset and limit of query to the estimated number of rows
+func limitEstimate(estimated int, query *Query) int {
+	estimated -= query.offset
+	if estimated < 0 {
+		return 0
+	}
+	if query.limit > 0 && query.limit < estimated {
+		return query.limit
+	}
+	return estimated
+}
+
+func operatorSymbol(op Operator) string {
+	switch op {
+	case opEq:
+		return "="
+	case OpGt:
+		return ">"
+	case OpGe:
+		return ">="
+	case OpLt:
+		return "<"
+	case OpLe:
+		return "<="
+	case OpNe:
+		return "!="
+	}
+	return "?"
+}
+
+// String prints the plan as indented text
+func (p *Plan) String() string {
+	var b strings.Builder
+	p.write(&b, "")
+	fmt.Fprintf(&b, "actual: %d index entries, %d keys visited, %d records decoded, %d rows\n", p.IndexEntries,
+		p.KeysVisited, p.RecordsDecoded, p.Rows)
+	return b.String()
+}
+
+func (p *Plan) write(b *strings.Builder, indent string) {
+	switch p.Scan {
+	case "sort":
+		fmt.Fprintf(b, "%ssort %s by %s in %s\n", indent, p.Type, strings.Join(p.Sort, ", "), p.SortBy)
+		p.Input.write(b, indent+"  ")
+	case "union":
+		order := "key order"
+		if p.Index != Key {
+			order = "order of " + p.Index
+		}
+		if p.Backward {
+			order = "reverse " + order
+		}
+		fmt.Fprintf(b, "%sunion of %d queries on %s in %s\n", indent, len(p.Or), p.Type, order)
+		for _, or := range p.Or {
+			or.write(b, indent+"  ")
+		}
+	default:
+		direction := "forward"
+		if p.Backward {
+			direction = "backward"
+		}
+		fmt.Fprintf(b, "%s%s scan of %s %s\n", indent, p.Scan, p.Bucket, direction)
+		if p.Lower != nil || p.Upper != nil {
+			var bounds []string
+			for _, bound := range []struct {
+				*Bound
+				op string
+			}{{p.Lower, ">"}, {p.Upper, "<"}} {
+				if bound.Bound == nil {
+					continue
+				}
+				op := bound.op
+				if bound.Inclusive {
+					op += "="
+				}
+				bounds = append(bounds, fmt.Sprintf("%s %v", op, bound.Value))
+			}
+			fmt.Fprintf(b, "%s  bounds: %s\n", indent, strings.Join(bounds, ", "))
+		}
+		if len(p.Values) > 0 {
+			fmt.Fprintf(b, "%s  values: %v\n", indent, p.Values)
+		}
+		if p.Excluded > 0 {
+			fmt.Fprintf(b, "%s  excluded values: %d\n", indent, p.Excluded)
+		}
+		if len(p.Filters) > 0 {
+			fmt.Fprintf(b, "%s  filters: %s\n", indent, strings.Join(p.Filters, ", "))
+		}
+		for _, and := range p.And {
+			fmt.Fprintf(b, "%s  and:\n", indent)
+			and.write(b, indent+"    ")
+		}
+	}
+
+	if p.Offset > 0 {
+		fmt.Fprintf(b, "%s  offset: %d, walked\n", indent, p.Offset)
+	}
+	if p.Limit > 0 {
+		fmt.Fprintf(b, "%s  limit: %d\n", indent, p.Limit)
+	}
+	fmt.Fprintf(b, "%s  estimated rows: %d\n", indent, p.Estimated)
+}
@@ -0,0 +1,123 @@
+package meson_bolt_localdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_explain(t *testing.T) {
+	store := coveredStore(t)
+
+	plan, err := store.Explain(&coveredRecord{}, NewQuery("Time").Range(Condition(OpGe, int64(3))).Limit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Plan{
+		Type:         "coveredRecord",
+		Scan:         "range",
+		Index:        "Time",
+		Bucket:       "_index:coveredRecord:Time",
+		Lower:        &Bound{Value: int64(3), Inclusive: true},
+		Limit:        2,
+		Estimated:    2,
+		IndexEntries: 1,
+		KeysVisited:  2,
+		Rows:         2,
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Fatalf("expected plan %+v, got %+v", expected, plan)
+	}
+	text := plan.String()
+	for _, line := range []string{
+		"range scan of _index:coveredRecord:Time forward\n",
+		"  bounds: >= 3\n",
+		"  limit: 2\n",
+		"actual: 1 index entries, 2 keys visited, 0 records decoded, 2 rows\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Fatalf("expected %q in the plan:\n%s", line, text)
+		}
+	}
+
+	tests := []struct {
+		query *Query
+		check func(plan *Plan) bool
+	}{
+		{NewQuery("Old").Range(Condition(OpLt, int64(3))), func(plan *Plan) bool {
+			return plan.Backward && plan.Upper.Value == int64(3) && !plan.Upper.Inclusive && plan.Rows == 2
+		}},
+		{NewQuery(Key).Where("Time", OpGt, 0), func(plan *Plan) bool {
+			return reflect.DeepEqual(plan.Filters, []string{"where Time > 0"}) && plan.RecordsDecoded == 5 &&
+				plan.Rows == 4
+		}},
+		{NewQuery("Time").Offset(3), func(plan *Plan) bool {
+			return plan.OffsetWalked && plan.KeysVisited == 5 && plan.Rows == 2 && plan.Estimated == 2
+		}},
+		{NewQuery("Name").Equal("x").OrderBy("Time", Desc), func(plan *Plan) bool {
+			return plan.Scan == "sort" && plan.SortBy == "index Old" && plan.Input.Scan == "equal" &&
+				reflect.DeepEqual(plan.Sort, []string{"Time desc"}) && plan.Rows == 2
+		}},
+		{NewQuery("Name").In("x", "z").And(NewQuery("Time").Equal(int64(3))), func(plan *Plan) bool {
+			return plan.Estimated == 2 && len(plan.And) == 1 && plan.And[0].Scan == "equal" && plan.Rows == 2 &&
+				plan.RecordsDecoded == 0
+		}},
+		{NewQuery("Time").Equal(int64(7)).Or(NewQuery(Key).Equal("e")), func(plan *Plan) bool {
+			return plan.Scan == "union" && len(plan.Or) == 2 && plan.Or[1].Bucket == "coveredRecord" &&
+				plan.Rows == 2
+		}},
+	}
+	for i, test := range tests {
+		plan, err := store.Explain(&coveredRecord{}, test.query)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !test.check(plan) {
+			t.Fatalf("query %d: unexpected plan %+v\n%s", i, plan, plan)
+		}
+	}
+
+	if _, err := store.Explain(&coveredRecord{}, NewQuery("Missing")); err == nil {
+		t.Fatal("expected an error for a missing index")
+	}
+}
+
+func Test_bucketSize(t *testing.T) {
+	store := openTestStore(t, nil)
+	err := store.Bolt().Update(func(tx *bolt.Tx) error {
+		if bucketSize(tx.Bucket([]byte("missing"))) != 0 {
+			t.Fatal("expected no keys in a missing bucket")
+		}
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			return err
+		}
+		large, err := tx.CreateBucket([]byte("large"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < bucketSampleSize+10; i++ {
+			if i < 3 {
+				if err := small.Put([]byte{byte(i)}, nil); err != nil {
+					return err
+				}
+			}
+			if err := large.Put([]byte(fmt.Sprintf("%08d", i)), nil); err != nil {
+				return err
+			}
+		}
+		if n := bucketSize(small); n != 3 {
+			t.Fatalf("expected 3 keys, got %d", n)
+		}
+		if n := bucketSize(large); n != bucketSampleSize {
+			t.Fatalf("expected the count to stop at %d keys, got %d", bucketSampleSize, n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
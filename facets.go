@@ -0,0 +1,193 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Facet is a distinct value of an index with the number of records holding it
+type Facet struct {
+	Value interface{}
+	Count int
+}
+
+// Distinct returns the distinct values of the index indexName held by the records of dataType matching query, read
+// from the index without decoding the records.  The values are decoded to the type of the indexed field, and the
+// collation of the index applies to them.  See Facets for how query applies
+func (s *Store) Distinct(dataType interface{}, indexName string, query *Query) ([]interface{}, error) {
+	var values []interface{}
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var err error
+		values, err = s.TxDistinct(tx, dataType, indexName, query)
+		return err
+	})
+	return values, err
+}
+
+// TxDistinct allows you to pass in your own bolt transaction to retrieve the distinct values of an index
+func (s *Store) TxDistinct(tx *bolt.Tx, dataType interface{}, indexName string, query *Query) ([]interface{},
+	error) {
+	facets, err := s.facetsQuery(tx, dataType, indexName, query)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(facets))
+	for i, facet := range facets {
+		values[i] = facet.Value
+	}
+	return values, nil
+}
+
+// Facets returns the distinct values of the index indexName held by the records of dataType matching query, with
+// the number of records holding each of them, read from the indexes without decoding the records.  A nil query
+// matches all the records.  The values come in index order, reversed by Desc, and the limit and offset of query
+// apply to the values.  When query is on another index, the values of indexName held by the matching records are
+// counted
+func (s *Store) Facets(dataType interface{}, indexName string, query *Query) ([]Facet, error) {
+	var facets []Facet
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var err error
+		facets, err = s.TxFacets(tx, dataType, indexName, query)
+		return err
+	})
+	return facets, err
+}
+
+// TxFacets allows you to pass in your own bolt transaction to retrieve the values of an index with their counts
+func (s *Store) TxFacets(tx *bolt.Tx, dataType interface{}, indexName string, query *Query) ([]Facet, error) {
+	return s.facetsQuery(tx, dataType, indexName, query)
+}
+
+func (s *Store) facetsQuery(source BucketSource, dataType interface{}, indexName string,
+	query *Query) ([]Facet, error) {
+	if indexName == Key {
+		return nil, errors.New("facets need an index")
+	}
+	if query == nil {
+		query = NewQuery(indexName)
+	}
+	err := checkQuery(&query)
+	if err != nil {
+		return nil, err
+	}
+
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := storer.Indexes()[indexName]
+	if !ok {
+		return nil, fmt.Errorf("index [%s] does not exist", indexName)
+	}
+	rType := recordType(dataType)
+	valueType := s.indexType(storer, rType, indexName)
+	if valueType == nil {
+		return nil, fmt.Errorf("type of the values of index [%s] is unknown, it's set by Index.Type", indexName)
+	}
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	facets := []Facet{}
+	mainBkt := source.Bucket([]byte(storer.Type()))
+	indexBkt := source.Bucket(indexBucketName(storer.Type(), indexName))
+	if mainBkt == nil || indexBkt == nil {
+		return facets, nil
+	}
+
+	offset := query.offset
+	// add adds the facet of an index value, as stored, and tells whether more are needed
+	add := func(stored []byte, count int) (bool, error) {
+		if offset > 0 {
+			offset--
+			return true, nil
+		}
+		encoded := stored
+		var err error
+		if index.Desc {
+			encoded, err = descValue(stored)
+			if err != nil {
+				return false, err
+			}
+		}
+		value := reflect.New(valueType)
+		err = s.decode(encoded, value.Interface())
+		if err != nil {
+			return false, err
+		}
+		facets = append(facets, Facet{Value: value.Elem().Interface(), Count: count})
+		return query.limit == 0 || len(facets) < query.limit, nil
+	}
+
+	all := *query
+	all.limit = 0
+	all.offset = 0
+
+	if query.index == indexName && len(query.or) == 0 && len(query.orderBy) == 0 {
+		// the keys come grouped by index value
+		var current []byte
+		count := 0
+		err = s.walkKeys(source, storer, mainBkt, rType, &all, func(_, value []byte) (bool, error) {
+			if count > 0 && !bytes.Equal(value, current) {
+				more, err := add(current, count)
+				if err != nil || !more {
+					count = 0
+					return false, err
+				}
+				count = 0
+			}
+			current = append(current[:0], value...)
+			count++
+			return true, nil
+		})
+		if err == nil && count > 0 {
+			_, err = add(current, count)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return facets, nil
+	}
+
+	all.reverse = false
+	all.orderBy = nil
+	matched, _, err := s.queryKeys(source, storer, mainBkt, rType, &all)
+	if err != nil {
+		return nil, err
+	}
+	matched = sortKeys(matched)
+
+	c := indexBkt.Cursor()
+	first, next := c.First, c.Next
+	if query.reverse != index.Desc {
+		first, next = c.Last, c.Prev
+	}
+	for k, v := first(); k != nil; k, v = next() {
+		var keys keyList
+		err := s.decode(v, &keys)
+		if err != nil {
+			return nil, err
+		}
+		count := 0
+		for _, key := range keys {
+			if matched.in(key) {
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		more, err := add(k, count)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+	return facets, nil
+}
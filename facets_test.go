@@ -0,0 +1,82 @@
+package meson_bolt_localdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_facets(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		index  string
+		query  *Query
+		facets []Facet
+	}{
+		{"Time", nil, []Facet{{int64(-2), 1}, {int64(1), 1}, {int64(3), 2}, {int64(7), 1}}},
+		{"Time", NewQuery("Time").Range(Condition(OpGe, int64(1))).Desc().Limit(2),
+			[]Facet{{int64(7), 1}, {int64(3), 2}}},
+		{"Old", nil, []Facet{{int64(-2), 1}, {int64(1), 1}, {int64(3), 2}, {int64(7), 1}}},
+		{"Old", NewQuery("Old").Desc().Offset(1), []Facet{{int64(3), 2}, {int64(1), 1}, {int64(-2), 1}}},
+		{"Old", NewQuery("Old").In(int64(1), int64(3)).Limit(1), []Facet{{int64(1), 1}}},
+		{"Name", nil, []Facet{{"x", 2}, {"y", 2}, {"z", 1}}},
+		{"Name", NewQuery("Time").Range(Condition(OpGe, int64(3))), []Facet{{"x", 2}, {"y", 1}}},
+		{"Name", NewQuery("Time").Range(Condition(OpGe, int64(3))).Desc().Limit(1), []Facet{{"y", 1}}},
+		{"Old", NewQuery("Name").Equal("z").Or(NewQuery(Key).Equal("d")).Desc(),
+			[]Facet{{int64(7), 1}, {int64(-2), 1}}},
+		{"Time", NewQuery("Name").Equal("w"), []Facet{}},
+	}
+	for i, test := range tests {
+		facets, err := store.Facets(&coveredRecord{}, test.index, test.query)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(facets, test.facets) {
+			t.Fatalf("query %d: expected facets %v, got %v", i, test.facets, facets)
+		}
+	}
+
+	values, err := store.Distinct(&coveredRecord{}, "Name", NewQuery("Name").Range(Condition(OpGt, "X")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []interface{}{"y", "z"}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected values %v, got %v", expected, values)
+	}
+
+	for _, index := range []string{"Missing", Key} {
+		if _, err := store.Facets(&coveredRecord{}, index, nil); err == nil {
+			t.Fatalf("expected an error for index %q", index)
+		}
+	}
+}
+
+func Test_storerFacets(t *testing.T) {
+	store := openTestStore(t, nil)
+	for i := 0; i < 3; i++ {
+		if err := store.Insert(i, typedRecord{ID: i, Size: uint64(i % 2 * 100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	facets, err := store.Facets(&typedRecord{}, "Size", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Facet{{uint64(0), 2}, {uint64(100), 1}}; !reflect.DeepEqual(facets, expected) {
+		t.Fatalf("expected facets %v, got %v", expected, facets)
+	}
+
+	values, err := store.Distinct(&typedRecord{}, "Size", NewQuery("Size").Range(Condition(OpGt, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []interface{}{uint64(100)}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected values %v, got %v", expected, values)
+	}
+
+	// the type of the values of an index without a Type is unknown
+	if _, err := store.Facets(&typedRecord{}, "Untyped", nil); err == nil {
+		t.Fatal("expected an error for an untyped index")
+	}
+}
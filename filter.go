@@ -0,0 +1,269 @@
+package meson_bolt_localdb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// whereCriterion compares the value of a field of the records
+type whereCriterion struct {
+	field string
+	op    Operator
+	value interface{}
+}
+
+// Where filters the records matched by the query on a field which doesn't need to be indexed, or a dotted path
+// to a nested field like "P.Name".  op is any Operator, including OpEq and OpNe.  The records are decoded to be
+// filtered, the limit and offset of the query apply to the filtered records.  Numbers of any kind are compared
+// to number fields, other values must be of the kind of the field or the query returns an error
+func (q *Query) Where(field string, op Operator, value interface{}) *Query {
+	q.where = append(q.where, &whereCriterion{field: field, op: op, value: value})
+	return q
+}
+
+// Filter filters the records matched by the query with match, which is passed a pointer to the decoded record.
+// The limit and offset of the query apply to the filtered records
+func (q *Query) Filter(match func(record interface{}) bool) *Query {
+	q.filters = append(q.filters, match)
+	return q
+}
+
+// recordType returns the struct type of the records of dataType
+func recordType(dataType interface{}) reflect.Type {
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	return tp
+}
+
+// recordMatcher returns the function matching a pointer to a record of type tp against the residual predicates
+// of query
+func (s *Store) recordMatcher(tp reflect.Type, query *Query) (func(record reflect.Value) bool, error) {
+	type compiledWhere struct {
+		*whereCriterion
+		steps []pathStep
+	}
+
+	wheres := make([]compiledWhere, 0, len(query.where))
+	for _, where := range query.where {
+		steps, fieldType, err := compilePath(tp, strings.Split(where.field, "."))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Where field %s: %s", where.field, err)
+		}
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if where.op != OpEq && where.op != OpNe && !orderedType(fieldType) {
+			return nil, fmt.Errorf("Where field %s of type %s can only be compared with OpEq or OpNe", where.field,
+				fieldType)
+		}
+		if value := reflect.ValueOf(where.value); derefValue(&value) && !comparableTypes(fieldType, value.Type()) {
+			return nil, fmt.Errorf("Where field %s of type %s can't be compared to a %s", where.field, fieldType,
+				value.Type())
+		}
+		wheres = append(wheres, compiledWhere{whereCriterion: where, steps: steps})
+	}
+
+	filters := query.filters
+	return func(record reflect.Value) bool {
+		for _, where := range wheres {
+			if !matchWhere(findPathValue(record.Interface(), where.steps), where.op, where.value) {
+				return false
+			}
+		}
+		for _, filter := range filters {
+			if !filter(record.Interface()) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// orderedType tells whether the values of tp can be compared with OpGt, OpGe, OpLt and OpLe
+func orderedType(tp reflect.Type) bool {
+	if tp == timeType {
+		return true
+	}
+	switch tp.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	}
+	return false
+}
+
+// comparableTypes tells whether the values of a field of type fieldType can be compared to the values of a Where
+// criterion of type valueType: numbers of any kind, strings, bools and times are compared with one another, the
+// other values only with values of the same kind convertible to the type of the field
+func comparableTypes(fieldType, valueType reflect.Type) bool {
+	switch {
+	case fieldType.Kind() == reflect.Interface:
+		// checked on each value
+		return true
+	case fieldType == timeType || valueType == timeType:
+		return fieldType == valueType
+	case numberKind(fieldType.Kind()) != reflect.Invalid:
+		return numberKind(valueType.Kind()) != reflect.Invalid
+	}
+	return fieldType.Kind() == valueType.Kind() && valueType.ConvertibleTo(fieldType)
+}
+
+// matchWhere compares the value of a field with the value of a Where criterion.  A nil field value, from a nil
+// pointer along the path, only equals a nil value
+func matchWhere(fieldValue interface{}, op Operator, value interface{}) bool {
+	a, b := reflect.ValueOf(fieldValue), reflect.ValueOf(value)
+	aOk, bOk := derefValue(&a), derefValue(&b)
+	if !aOk || !bOk {
+		switch op {
+		case OpEq:
+			return aOk == bOk
+		case OpNe:
+			return aOk != bOk
+		}
+		return false
+	}
+
+	cmp, err := compareValues(a, b)
+	if err != nil {
+		// not ordered, only equality can be tested, values of other types are different
+		equal := comparableTypes(a.Type(), b.Type()) && reflect.DeepEqual(a.Interface(),
+			b.Convert(a.Type()).Interface())
+		switch op {
+		case OpEq:
+			return equal
+		case OpNe:
+			return !equal
+		}
+		return false
+	}
+
+	switch op {
+	case OpEq:
+		return cmp == 0
+	case OpNe:
+		return cmp != 0
+	case OpGt:
+		return cmp > 0
+	case OpGe:
+		return cmp >= 0
+	case OpLt:
+		return cmp < 0
+	case OpLe:
+		return cmp <= 0
+	}
+	return false
+}
+
+var errNotOrdered = errors.New("values can't be ordered")
+
+// compareValues compares two numbers of any kind, strings, bools or times, returning -1, 0 or 1
+func compareValues(a, b reflect.Value) (int, error) {
+	if a.Type() == timeType && b.Type() == timeType {
+		ta, tb := a.Interface().(time.Time), b.Interface().(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1, nil
+		case ta.After(tb):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	ka, kb := numberKind(a.Kind()), numberKind(b.Kind())
+	switch {
+	case ka == reflect.Float64 || kb == reflect.Float64:
+		if ka == reflect.Invalid || kb == reflect.Invalid {
+			return 0, errNotOrdered
+		}
+		return compareFloats(toFloat(a), toFloat(b)), nil
+	case ka == reflect.Int64 && kb == reflect.Int64:
+		return compareInts(a.Int(), b.Int()), nil
+	case ka == reflect.Uint64 && kb == reflect.Uint64:
+		return compareUints(a.Uint(), b.Uint()), nil
+	case ka == reflect.Int64 && kb == reflect.Uint64:
+		if a.Int() < 0 {
+			return -1, nil
+		}
+		return compareUints(uint64(a.Int()), b.Uint()), nil
+	case ka == reflect.Uint64 && kb == reflect.Int64:
+		if b.Int() < 0 {
+			return 1, nil
+		}
+		return compareUints(a.Uint(), uint64(b.Int())), nil
+	}
+
+	switch {
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		return strings.Compare(a.String(), b.String()), nil
+	case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+		if a.Bool() == b.Bool() {
+			return 0, nil
+		}
+		if b.Bool() {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return 0, errNotOrdered
+}
+
+// numberKind returns Int64, Uint64 or Float64 for the numeric kinds, and Invalid for the others
+func numberKind(kind reflect.Kind) reflect.Kind {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int64
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return reflect.Uint64
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return reflect.Invalid
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch numberKind(v.Kind()) {
+	case reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
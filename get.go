@@ -2,8 +2,8 @@ package meson_bolt_localdb
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
-	"strings"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -23,13 +23,30 @@ func (s *Store) TxGet(tx *bolt.Tx, key, result interface{}) error {
 	return s.get(tx, key, result)
 }
 
+// GetBy retrieves into result the record whose value of the unique index indexName is value, found with a
+// lookup of the index bucket.  The value is converted to the type of the indexed field.  If no record holds the
+// value, then it returns ErrNotFound
+func (s *Store) GetBy(result interface{}, indexName string, value interface{}) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxGetBy(tx, result, indexName, value)
+	})
+}
+
+// TxGetBy allows you to pass in your own bolt transaction to retrieve a record by the value of a unique index
+func (s *Store) TxGetBy(tx *bolt.Tx, result interface{}, indexName string, value interface{}) error {
+	return s.getBy(tx, result, indexName, value)
+}
+
 // GetFromBucket allows you to specify the parent bucket for retrieving records
 //func (s *Store) GetFromBucket(parent *bolt.Bucket, key, result interface{}) error {
 //	return s.get(parent, key, result)
 //}
 
 func (s *Store) get(source BucketSource, key, result interface{}) error {
-	storer := s.newStorer(result)
+	storer, err := s.newStorer(result)
+	if err != nil {
+		return err
+	}
 
 	gk, err := s.encode(key)
 
@@ -43,7 +60,7 @@ func (s *Store) get(source BucketSource, key, result interface{}) error {
 	}
 
 	value := bkt.Get(gk)
-	if value == nil {
+	if value == nil || s.expired(source, storer, gk) {
 		return ErrNotFound
 	}
 
@@ -52,28 +69,67 @@ func (s *Store) get(source BucketSource, key, result interface{}) error {
 		return err
 	}
 
-	tp := reflect.TypeOf(result)
-	for tp.Kind() == reflect.Ptr {
-		tp = tp.Elem()
-	}
+	return s.decodeKey(gk, reflect.ValueOf(result))
+}
 
-	var keyField string
+func (s *Store) getBy(source BucketSource, result interface{}, indexName string, value interface{}) error {
+	if indexName == Key {
+		return s.get(source, value, result)
+	}
+	storer, err := s.newStorer(result)
+	if err != nil {
+		return err
+	}
+	index, ok := storer.Indexes()[indexName]
+	if !ok {
+		return fmt.Errorf("index [%s] does not exist", indexName)
+	}
+	if !index.Unique {
+		return fmt.Errorf("index [%s] is not unique", indexName)
+	}
+	if value == nil {
+		return errors.New("index value is nil")
+	}
 
-	for i := 0; i < tp.NumField(); i++ {
-		if strings.Contains(string(tp.Field(i).Tag), BoltholdKeyTag) {
-			keyField = tp.Field(i).Name
-			break
+	if tp := s.indexType(storer, recordType(result), indexName); tp != nil {
+		value, err = convertValue(value, tp)
+		if err != nil {
+			return fmt.Errorf("value of index [%s]: %s", indexName, err)
 		}
 	}
+	encoded, err := s.encodeQueryValue(storer, indexName, value)
+	if err != nil {
+		return err
+	}
+
+	mainBkt := source.Bucket([]byte(storer.Type()))
+	indexBkt := source.Bucket(indexBucketName(storer.Type(), indexName))
+	if mainBkt == nil || indexBkt == nil {
+		return ErrNotFound
+	}
+	stored := indexBkt.Get(encoded)
+	if stored == nil {
+		return ErrNotFound
+	}
+	var keys keyList
+	err = s.decode(stored, &keys)
+	if err != nil {
+		return err
+	}
 
-	if keyField != "" {
-		err := s.decode(gk, reflect.ValueOf(result).Elem().FieldByName(keyField).Addr().Interface())
+	// the zero value of an IgnoreZero index can be held by several records
+	for _, key := range keys {
+		record := mainBkt.Get(key)
+		if record == nil || s.expired(source, storer, key) {
+			continue
+		}
+		err = s.decode(record, result)
 		if err != nil {
 			return err
 		}
+		return s.decodeKey(key, reflect.ValueOf(result))
 	}
-
-	return nil
+	return ErrNotFound
 }
 
 // Find retrieves a set of values from the bolthold that matches the passed in query
@@ -96,8 +152,38 @@ func (s *Store) TxFind(tx *bolt.Tx, result interface{}, query *Query) error {
 //	return s.findQuery(parent, result, query)
 //}
 
-// FindOne returns a single record, and so result is NOT a slice, but an pointer to a struct, if no record is found
-// that matches the query, then it returns ErrNotFound
+// FindKeys retrieves the keys of the records of dataType that match the passed in query, without decoding the
+// records.  keys must be a pointer to a slice of the key type, for instance *[]string
+func (s *Store) FindKeys(dataType, keys interface{}, query *Query) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxFindKeys(tx, dataType, keys, query)
+	})
+}
+
+// TxFindKeys allows you to pass in your own bolt transaction to retrieve the keys of the records matching a query
+func (s *Store) TxFindKeys(tx *bolt.Tx, dataType, keys interface{}, query *Query) error {
+	return s.findKeysQuery(tx, dataType, keys, query, false)
+}
+
+// FindIndexValues retrieves the values of the query index held by the records of dataType that match the
+// passed in query, straight from the index.  Each value is returned once, in index order.  The limit and offset
+// of the query apply to the records, not the values.  values must be a pointer to a slice of the indexed type,
+// for instance *[]int64, the collation of the index applies to the returned values
+func (s *Store) FindIndexValues(dataType, values interface{}, query *Query) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxFindIndexValues(tx, dataType, values, query)
+	})
+}
+
+// TxFindIndexValues allows you to pass in your own bolt transaction to retrieve the index values of the records
+// matching a query
+func (s *Store) TxFindIndexValues(tx *bolt.Tx, dataType, values interface{}, query *Query) error {
+	return s.findKeysQuery(tx, dataType, values, query, true)
+}
+
+// FindOne returns a single record, and so result is NOT a slice, but a pointer to a struct, with its key field
+// filled.  If no record is found that matches the query, then it returns ErrNotFound.  A pointer to a slice still
+// receives at most one record, without ErrNotFound, for compatibility
 func (s *Store) FindOne(result interface{}, query *Query) error {
 	return s.Bolt().View(func(tx *bolt.Tx) error {
 		return s.TxFindOne(tx, result, query)
@@ -0,0 +1,111 @@
+package meson_bolt_localdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_findOne(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query *Query
+		key   string
+	}{
+		{nil, "a"},
+		{NewQuery("Time").Range().Desc(), "d"},
+		{NewQuery("Time").Equal(3).Offset(1), "c"},
+		{NewQuery("Name").Equal("Y").OrderBy("Hash", Desc), "d"},
+	}
+	for i, test := range tests {
+		var record coveredRecord
+		if err := store.FindOne(&record, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if record.Hash != test.key {
+			t.Fatalf("query %d: expected record %s, got %+v", i, test.key, record)
+		}
+	}
+
+	query := NewQuery("Time").Range(Condition(OpGt, 7))
+	if err := store.FindOne(&coveredRecord{}, query); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := openTestStore(t, nil).FindOne(&coveredRecord{}, nil); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound without records, got %v", err)
+	}
+
+	// the query isn't changed, and slices are still filled
+	query = NewQuery("Time").Range()
+	var records []coveredRecord
+	if err := store.FindOne(&records, query); err != nil || len(records) != 1 || records[0].Hash != "e" {
+		t.Fatalf("expected record e in the slice, got %+v (%v)", records, err)
+	}
+	if query.limit != 0 {
+		t.Fatalf("expected the query limit to be left alone, got %d", query.limit)
+	}
+}
+
+type uniqueKeyedRecord struct {
+	ID    int    `mesondb:"key"`
+	Email string `mesondb:"unique,collate=nocase"`
+	No    uint32 `mesondb:"unique,desc"`
+	Group string `mesondb:"index"`
+}
+
+func Test_getBy(t *testing.T) {
+	store := openTestStore(t, nil)
+	for i, email := range []string{"a@x.org", "B@x.org", "c@x.org"} {
+		r := uniqueKeyedRecord{Email: email, No: uint32(100 + i), Group: "g"}
+		if err := store.Insert(i+1, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		index string
+		value interface{}
+		id    int
+	}{
+		{"Email", "c@x.org", 3},
+		{"Email", "b@X.ORG", 2},
+		{"No", 100, 1},
+		{"No", uint32(101), 2},
+		{Key, 3, 3},
+	}
+	for i, test := range tests {
+		var record uniqueKeyedRecord
+		if err := store.GetBy(&record, test.index, test.value); err != nil {
+			t.Fatalf("lookup %d: %s", i, err)
+		}
+		if record.ID != test.id {
+			t.Fatalf("lookup %d: expected record %d, got %+v", i, test.id, record)
+		}
+	}
+
+	if err := store.GetBy(&uniqueKeyedRecord{}, "Email", "d@x.org"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.Delete(2, &uniqueKeyedRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GetBy(&uniqueKeyedRecord{}, "No", 101); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a deleted record, got %v", err)
+	}
+
+	for _, test := range []struct {
+		index string
+		value interface{}
+		err   string
+	}{
+		{"Group", "g", "not unique"},
+		{"Missing", "g", "does not exist"},
+		{"No", -1, "doesn't fit"},
+		{"Email", nil, "nil"},
+	} {
+		err := store.GetBy(&uniqueKeyedRecord{}, test.index, test.value)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected an error containing %q for %s, got %v", test.err, test.index, err)
+		}
+	}
+}
@@ -2,14 +2,18 @@ package meson_bolt_localdb
 
 import (
 	"bytes"
+	"errors"
+	"reflect"
 	"sort"
+
+	"github.com/daqnext/meson-bolt-localdb/internal/fieldtag"
 )
 
 // BoltholdIndexTag is the struct tag used to define a field as indexable for a bolthold
-const BoltholdIndexTag = "boltholdIndex"
+const BoltholdIndexTag = fieldtag.BoltholdIndex
 
 // BoltholdUniqueTag is the struct tag used to define a field as unique constraint
-const BoltholdUniqueTag = "boltholdUnique"
+const BoltholdUniqueTag = fieldtag.BoltholdUnique
 
 // BoltholdSliceIndexTag is the struct tag used to define a slice field as indexable, where each item in the
 // slice is indexed separately rather than as one index
@@ -23,7 +27,27 @@ const iteratorKeyMinCacheSize = 100
 // Index is a function that returns the indexable, encoded bytes of the passed in value
 type Index struct {
 	IndexFunc func(name string, value interface{}) ([]byte, error)
+	// ValueFunc can be set instead of IndexFunc, it returns the value to index which is then encoded with the
+	// store's encoder, or nil if the record isn't indexed
+	ValueFunc func(value interface{}) interface{}
 	Unique    bool
+	// OmitZero skips indexing records holding the zero value
+	OmitZero bool
+	// IgnoreZero relaxes the unique constraint for records holding the zero value, the same way SQL unique
+	// constraints ignore NULLs.  Those records are still indexed
+	IgnoreZero bool
+	// ZeroValue is the encoded zero value, needed by OmitZero and IgnoreZero when IndexFunc is used
+	ZeroValue []byte
+	// Collate is the name of the collation applied to the values returned by ValueFunc and to query values,
+	// "nocase" compares strings without case
+	Collate string
+	// Desc stores the index in descending order of its values, so queries sorted by descending values walk it
+	// forward
+	Desc bool
+	// Type is the type of the indexed values, query values are converted to it so they're encoded like them.  It's
+	// found from the indexed field for tagged types, the indexes of a Storer leaving it nil must be queried with
+	// values of the indexed type
+	Type reflect.Type
 }
 
 // SliceIndex is a function that returns all of the indexable values in a slice
@@ -43,14 +67,30 @@ func (s *Store) deleteIndexes(storer Storer, source BucketSource, key []byte, or
 func (s *Store) updateIndexes(storer Storer, source BucketSource, key []byte, data interface{}, delete bool) error {
 	indexes := storer.Indexes()
 	for name, index := range indexes {
-		indexKey, err := index.IndexFunc(name, data)
+		indexKey, zero, err := s.indexKey(name, index, data)
 		if err != nil {
 			return err
 		}
-		if indexKey == nil {
+		if indexKey == nil || (zero && index.OmitZero) {
 			continue
 		}
-		err = s.updateIndex(storer.Type(), name, index.Unique, indexKey, source, key, delete)
+		unique := index.Unique && !(zero && index.IgnoreZero)
+		storedKey := indexKey
+		if index.Desc {
+			storedKey = descKey(indexKey)
+		}
+		err = s.updateIndex(storer.Type(), name, unique, storedKey, source, key, delete)
+		if err != nil {
+			var uErr *UniqueExistsError
+			if errors.As(err, &uErr) {
+				uErr.Value = indexKey
+			}
+			return err
+		}
+	}
+
+	if expires := storerExpiry(storer); expires != nil {
+		err := s.updateExpiry(source, storer.Type(), key, expires(data), delete)
 		if err != nil {
 			return err
 		}
@@ -77,6 +117,76 @@ func (s *Store) updateIndexes(storer Storer, source BucketSource, key []byte, da
 	return nil
 }
 
+// indexKey returns the encoded value of the index for data, nil if data isn't indexed, and whether it's the
+// zero value
+func (s *Store) indexKey(name string, index Index, data interface{}) ([]byte, bool, error) {
+	if index.ValueFunc == nil {
+		indexKey, err := index.IndexFunc(name, data)
+		if err != nil || indexKey == nil {
+			return nil, false, err
+		}
+		return indexKey, index.ZeroValue != nil && bytes.Equal(indexKey, index.ZeroValue), nil
+	}
+
+	value := index.ValueFunc(data)
+	if value == nil {
+		return nil, false, nil
+	}
+	value = collate(index.Collate, value)
+	val := reflect.ValueOf(value)
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		return nil, false, nil
+	}
+
+	indexKey, err := s.encode(value)
+	if err != nil {
+		return nil, false, err
+	}
+	return indexKey, val.IsZero(), nil
+}
+
+// descKey returns the key of a descending index for the encoded value: zero bytes are escaped as 0x00 0xFF and
+// the value is terminated by 0x00 0x00, so no key is a prefix of another, then every byte is inverted
+func descKey(value []byte) []byte {
+	key := make([]byte, 0, len(value)+2)
+	for _, b := range value {
+		if b == 0 {
+			key = append(key, 0xFF, 0x00)
+		} else {
+			key = append(key, ^b)
+		}
+	}
+	return append(key, 0xFF, 0xFF)
+}
+
+// descValue returns the encoded value of a key of a descending index
+func descValue(key []byte) ([]byte, error) {
+	value := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		b := ^key[i]
+		if b != 0 {
+			value = append(value, b)
+			continue
+		}
+		if i+1 >= len(key) {
+			break
+		}
+		i++
+		switch ^key[i] {
+		case 0xFF:
+			value = append(value, 0)
+		case 0x00:
+			if i+1 == len(key) {
+				return value, nil
+			}
+			return nil, errors.New("invalid descending index key")
+		default:
+			return nil, errors.New("invalid descending index key")
+		}
+	}
+	return nil, errors.New("descending index key isn't terminated")
+}
+
 // adds or removes a specific index on an item
 func (s *Store) updateIndex(typeName, indexName string, unique bool, indexKey []byte, source BucketSource, key []byte,
 	delete bool) error {
@@ -89,15 +199,23 @@ func (s *Store) updateIndex(typeName, indexName string, unique bool, indexKey []
 
 	iVal := b.Get(indexKey)
 	if iVal != nil {
-		if unique && !delete {
-			return ErrUniqueExists
-		}
-
 		err = s.decode(iVal, &indexValue)
 		if err != nil {
 			return err
 		}
 
+		if unique && !delete {
+			uErr := &UniqueExistsError{
+				TypeName:  typeName,
+				IndexName: indexName,
+				Value:     indexKey,
+				decode:    s.decode,
+			}
+			if len(indexValue) > 0 {
+				uErr.ExistingKey = indexValue[0]
+			}
+			return uErr
+		}
 	}
 
 	if delete {
@@ -173,6 +291,32 @@ func (v *keyList) in(key []byte) bool {
 	return i < len(*v) && bytes.Equal((*v)[i], key)
 }
 
+// sortKeys sorts keys so they can be searched like a keyList
+func sortKeys(keys keyList) keyList {
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// intersect returns the keys of the sorted list a also in the sorted list b
+func intersect(a, b keyList) keyList {
+	result := make(keyList, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch bytes.Compare(a[i], b[j]) {
+		case -1:
+			i++
+		case 1:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
 // seekCursor attempts to save reads by seeking the cursor past values it doesn't need to compare since keys
 // are stored in order
 //func (s *Store) seekCursor(cursor *bolt.Cursor, criteria []*Criterion) (key, value []byte) {
@@ -0,0 +1,165 @@
+// Package fieldtag parses the struct tags configuring the key, expiry and indexes of a field.  It is shared by
+// the store, which reads the tags by reflection, and mesondb-gen, which reads them from the source, so generated
+// indexes always match the ones found at runtime
+package fieldtag
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// names of the struct tags
+const (
+	MesonDB        = "mesondb"
+	BoltholdKey    = "boltholdKey"
+	BoltholdIndex  = "boltholdIndex"
+	BoltholdUnique = "boltholdUnique"
+)
+
+// Collations transform the values of indexes declared with a collate option, both when indexing records and
+// when encoding query values
+var Collations = map[string]func(value interface{}) interface{}{
+	"binary": nil,
+	"nocase": func(value interface{}) interface{} {
+		val := reflect.ValueOf(value)
+		if val.Kind() != reflect.String {
+			return value
+		}
+		return reflect.ValueOf(strings.ToLower(val.String())).Convert(val.Type()).Interface()
+	},
+}
+
+// Tag is the configuration of a field read from its mesondb tag, or the legacy bolthold tags
+type Tag struct {
+	Key        bool
+	Expires    bool
+	Index      bool
+	Unique     bool
+	Name       string
+	Path       string
+	OmitZero   bool
+	IgnoreZero bool
+	Desc       bool
+	Collate    string
+}
+
+// Parse reads the tags of a field, returning an error if they are invalid
+func Parse(structTag reflect.StructTag) (Tag, error) {
+	var tag Tag
+
+	_, legacyKey := structTag.Lookup(BoltholdKey)
+	legacyIndex, legacyIndexed := structTag.Lookup(BoltholdIndex)
+	legacyUnique, legacyUniqued := structTag.Lookup(BoltholdUnique)
+	legacy := legacyKey || legacyIndexed || legacyUniqued
+
+	value, ok := structTag.Lookup(MesonDB)
+	if ok && legacy {
+		return tag, fmt.Errorf("the %s tag can't be combined with bolthold tags", MesonDB)
+	}
+
+	var options map[string]string
+	if ok {
+		options = make(map[string]string)
+		for _, option := range strings.Split(value, ",") {
+			option = strings.TrimSpace(option)
+			if option == "" {
+				continue
+			}
+			kv := strings.SplitN(option, "=", 2)
+			if _, ok := options[kv[0]]; ok {
+				return tag, fmt.Errorf("option %s is repeated", kv[0])
+			}
+			if len(kv) == 2 {
+				options[kv[0]] = kv[1]
+			} else {
+				options[kv[0]] = ""
+			}
+		}
+		for _, flag := range []struct {
+			name  string
+			value *bool
+		}{{"key", &tag.Key}, {"expires", &tag.Expires}, {"index", &tag.Index}, {"unique", &tag.Unique}} {
+			if v, ok := options[flag.name]; ok {
+				if v != "" {
+					return tag, fmt.Errorf("option %s doesn't take a value", flag.name)
+				}
+				*flag.value = true
+				delete(options, flag.name)
+			}
+		}
+		if name, ok := options["name"]; ok {
+			tag.Name = name
+			delete(options, "name")
+		}
+	} else {
+		if legacyIndexed && legacyUniqued {
+			return tag, fmt.Errorf("the %s and %s tags can't be combined", BoltholdIndex, BoltholdUnique)
+		}
+		tag.Key = legacyKey
+		if legacyIndexed {
+			tag.Index = true
+			tag.Name, options = parseLegacy(legacyIndex)
+		} else if legacyUniqued {
+			tag.Unique = true
+			tag.Name, options = parseLegacy(legacyUnique)
+		}
+	}
+
+	for option, v := range options {
+		switch option {
+		case "omitzero", "ignorezero", "desc":
+			if v != "" {
+				return tag, fmt.Errorf("option %s doesn't take a value", option)
+			}
+		}
+		switch option {
+		case "path":
+			tag.Path = v
+		case "omitzero":
+			tag.OmitZero = true
+		case "ignorezero":
+			tag.IgnoreZero = true
+		case "collate":
+			if _, ok := Collations[v]; !ok {
+				return tag, fmt.Errorf("unknown collation %q", v)
+			}
+			tag.Collate = v
+		case "desc":
+			tag.Desc = true
+		default:
+			return tag, fmt.Errorf("unknown option %q", option)
+		}
+	}
+
+	if !tag.Index && !tag.Unique && (tag.Name != "" || len(options) > 0) {
+		return tag, fmt.Errorf("index options are set but the field isn't indexed")
+	}
+	if tag.IgnoreZero && !tag.Unique {
+		return tag, fmt.Errorf("ignorezero only applies to unique indexes")
+	}
+	if tag.Path != "" && strings.Contains(tag.Path, "..") {
+		return tag, fmt.Errorf("invalid path %q", tag.Path)
+	}
+
+	return tag, nil
+}
+
+// parseLegacy splits a bolthold tag value of the form "name,option=value,flag" into the name and its options
+func parseLegacy(value string) (string, map[string]string) {
+	parts := strings.Split(value, ",")
+	options := make(map[string]string)
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) == 2 {
+			options[kv[0]] = kv[1]
+		} else {
+			options[kv[0]] = ""
+		}
+	}
+	return strings.TrimSpace(parts[0]), options
+}
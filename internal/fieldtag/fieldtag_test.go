@@ -0,0 +1,48 @@
+package fieldtag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parse(t *testing.T) {
+	tests := []struct {
+		tag      reflect.StructTag
+		expected Tag
+	}{
+		{`mesondb:"index" json:"boltholdKey"`, Tag{Index: true}},
+		{`mesondb:"key,expires"`, Tag{Key: true, Expires: true}},
+		{`mesondb:"unique,name=Mail,ignorezero,collate=nocase,desc"`,
+			Tag{Unique: true, Name: "Mail", IgnoreZero: true, Collate: "nocase", Desc: true}},
+		{`mesondb:"index,path=P.Name,omitzero"`, Tag{Index: true, Path: "P.Name", OmitZero: true}},
+		{`json:"boltholdIndex" boltholdUnique:"Mail,ignorezero"`, Tag{Unique: true, Name: "Mail", IgnoreZero: true}},
+		{`boltholdKey:"" boltholdIndex:"Name,desc"`, Tag{Key: true, Index: true, Name: "Name", Desc: true}},
+	}
+	for i, test := range tests {
+		tag, err := Parse(test.tag)
+		if err != nil {
+			t.Fatalf("tag %d: %s", i, err)
+		}
+		if tag != test.expected {
+			t.Fatalf("tag %d: expected %+v, got %+v", i, test.expected, tag)
+		}
+	}
+
+	for _, tag := range []reflect.StructTag{
+		`mesondb:"index" boltholdIndex:"Name"`,
+		`mesondb:"index,index"`,
+		`mesondb:"key=ID"`,
+		`mesondb:"index,omitzero=x"`,
+		`mesondb:"index,sorted"`,
+		`mesondb:"index,collate=french"`,
+		`mesondb:"index,ignorezero"`,
+		`mesondb:"omitzero"`,
+		`mesondb:"index,path=P..Name"`,
+		`boltholdIndex:"Name" boltholdUnique:"Name"`,
+		`boltholdIndex:"Name,desc=1"`,
+	} {
+		if _, err := Parse(tag); err == nil {
+			t.Fatalf("expected an error for tag %s", tag)
+		}
+	}
+}
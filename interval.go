@@ -0,0 +1,124 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Between matches the records whose index value is between lower and upper, both included.  It's a Range with
+// an OpGe and an OpLe condition, and can be combined with other conditions
+func (q *Query) Between(lower, upper interface{}) *Query {
+	return q.Range(Condition(OpGe, lower), Condition(OpLe, upper))
+}
+
+// bound is a bound of an interval of encoded index values, set by a range criterion
+type bound struct {
+	value     []byte
+	inclusive bool
+	criterion *Criterion
+}
+
+// interval is the interval of encoded index values matching the range criteria of a query, nil bounds are
+// unbounded
+type interval struct {
+	lower, upper *bound
+}
+
+// rangeInterval collapses the range criteria of query into an interval: the greatest lower bound and the least
+// upper bound, an exclusive bound winning over an inclusive one on the same value.  The criteria can come in any
+// order and number
+func (s *Store) rangeInterval(storer Storer, query *Query) (*interval, error) {
+	in := &interval{}
+	for _, c := range query.rangeCriteria {
+		encoded, err := s.encodeQueryValue(storer, query.index, c.value)
+		if err != nil {
+			return nil, fmt.Errorf("query value encode err:%s", err.Error())
+		}
+		b := &bound{value: encoded, inclusive: c.op == OpGe || c.op == OpLe, criterion: c}
+		switch c.op {
+		case OpGt, OpGe:
+			if in.lower == nil || tighter(b, in.lower, 1) {
+				in.lower = b
+			}
+		case OpLt, OpLe:
+			if in.upper == nil || tighter(b, in.upper, -1) {
+				in.upper = b
+			}
+		}
+	}
+	return in, nil
+}
+
+// tighter tells whether b restricts an interval more than other, sign is 1 for lower bounds and -1 for upper
+// bounds
+func tighter(b, other *bound, sign int) bool {
+	cmp := bytes.Compare(b.value, other.value) * sign
+	return cmp > 0 || (cmp == 0 && !b.inclusive && other.inclusive)
+}
+
+// empty tells whether no value can be in the interval
+func (in *interval) empty() bool {
+	if in.lower == nil || in.upper == nil {
+		return false
+	}
+	cmp := bytes.Compare(in.lower.value, in.upper.value)
+	return cmp > 0 || (cmp == 0 && !(in.lower.inclusive && in.upper.inclusive))
+}
+
+// aboveLower tells whether k is within the lower bound
+func (in *interval) aboveLower(k []byte) bool {
+	if in.lower == nil {
+		return true
+	}
+	cmp := bytes.Compare(k, in.lower.value)
+	return cmp > 0 || (cmp == 0 && in.lower.inclusive)
+}
+
+// belowUpper tells whether k is within the upper bound
+func (in *interval) belowUpper(k []byte) bool {
+	if in.upper == nil {
+		return true
+	}
+	cmp := bytes.Compare(k, in.upper.value)
+	return cmp < 0 || (cmp == 0 && in.upper.inclusive)
+}
+
+// first moves c to the first key of the interval, its last key if reverse is set.  The key may be out of the
+// interval when none is in it
+func (in *interval) first(c *bolt.Cursor, reverse bool) ([]byte, []byte) {
+	if !reverse {
+		if in.lower == nil {
+			return c.First()
+		}
+		k, v := c.Seek(in.lower.value)
+		if k != nil && !in.aboveLower(k) {
+			return c.Next()
+		}
+		return k, v
+	}
+
+	if in.upper == nil {
+		return c.Last()
+	}
+	k, v := c.Seek(in.upper.value)
+	if k == nil {
+		return c.Last()
+	}
+	if !in.belowUpper(k) {
+		return c.Prev()
+	}
+	return k, v
+}
+
+// walkable tells whether k, reached from the first key of the interval, is still in it
+func (in *interval) walkable(k []byte, reverse bool) bool {
+	if k == nil {
+		return false
+	}
+	if reverse {
+		return in.aboveLower(k)
+	}
+	return in.belowUpper(k)
+}
@@ -0,0 +1,161 @@
+package meson_bolt_localdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// matchBounds returns the keys of the records of coveredStore matching criteria, in the order of a query on Time,
+// reversed by desc
+func matchBounds(criteria []*Criterion, desc bool) []string {
+	records := []coveredRecord{{Hash: "a", Time: 3}, {Hash: "b", Time: 1}, {Hash: "c", Time: 3}, {Hash: "d", Time: 7},
+		{Hash: "e", Time: -2}}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Time != records[j].Time {
+			return (records[i].Time < records[j].Time) != desc
+		}
+		return records[i].Hash < records[j].Hash
+	})
+
+	keys := []string{}
+	for _, r := range records {
+		match := true
+		for _, c := range criteria {
+			v := c.value.(int64)
+			switch c.op {
+			case OpGt:
+				match = match && r.Time > v
+			case OpGe:
+				match = match && r.Time >= v
+			case OpLt:
+				match = match && r.Time < v
+			case OpLe:
+				match = match && r.Time <= v
+			}
+		}
+		if match {
+			keys = append(keys, r.Hash)
+		}
+	}
+	return keys
+}
+
+func Test_rangeInterval(t *testing.T) {
+	store := coveredStore(t)
+
+	run := func(name string, criteria []*Criterion) {
+		t.Helper()
+		for _, index := range []string{"Time", "Old"} {
+			for _, desc := range []bool{false, true} {
+				query := NewQuery(index).Range(criteria...)
+				if desc {
+					query.Desc()
+				}
+				keys := []string{"stale"}
+				if err := store.FindKeys(&coveredRecord{}, &keys, query); err != nil {
+					t.Fatalf("%s on %s, desc %v: %s", name, index, desc, err)
+				}
+				if expected := matchBounds(criteria, desc); !reflect.DeepEqual(keys, expected) {
+					t.Fatalf("%s on %s, desc %v: expected keys %v, got %v", name, index, desc, expected, keys)
+				}
+			}
+		}
+	}
+
+	operators := []Operator{OpGt, OpGe, OpLt, OpLe}
+	values := []int64{-3, -2, 0, 1, 3, 5, 7, 8}
+	var bounds []*Criterion
+	for _, op := range operators {
+		for _, v := range values {
+			bounds = append(bounds, Condition(op, v))
+		}
+	}
+	describe := func(criteria ...*Criterion) string {
+		name := ""
+		for _, c := range criteria {
+			name += fmt.Sprintf(" %s %d", operatorSymbol(c.op), c.value)
+		}
+		return name
+	}
+
+	run("unbounded", nil)
+	for _, a := range bounds {
+		run(describe(a), []*Criterion{a})
+		for _, b := range bounds {
+			run(describe(a, b), []*Criterion{a, b})
+		}
+	}
+	// three bounds, on the values of the records
+	var some []*Criterion
+	for _, op := range operators {
+		for _, v := range []int64{1, 3, 7} {
+			some = append(some, Condition(op, v))
+		}
+	}
+	for _, a := range some {
+		for _, b := range some {
+			for _, c := range some {
+				run(describe(a, b, c), []*Criterion{a, b, c})
+			}
+		}
+	}
+}
+
+func Test_between(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query *Query
+		keys  []string
+	}{
+		{NewQuery("Time").Between(1, 3), []string{"b", "a", "c"}},
+		{NewQuery("Time").Between(1, 3).Desc(), []string{"a", "c", "b"}},
+		{NewQuery("Old").Between(1, 3).Desc(), []string{"a", "c", "b"}},
+		{NewQuery("Old").Between(-2, 7).Limit(2).Offset(1), []string{"b", "a"}},
+		{NewQuery("Time").Between(3, 3), []string{"a", "c"}},
+		{NewQuery("Time").Between(3, 1), []string{}},
+		{NewQuery("Time").Between(-10, 10).Range(Condition(OpGt, 1), Condition(OpLt, 7)), []string{"a", "c"}},
+		{NewQuery("Time").Between(1, 7).NotIn(3), []string{"b", "d"}},
+		{NewQuery(Key).Between("b", "d"), []string{"b", "c", "d"}},
+		{NewQuery(Key).Between("b", "d").Range(Condition(OpGt, "b")).Desc(), []string{"d", "c"}},
+	}
+	for i, test := range tests {
+		keys := []string{"stale"}
+		if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+	}
+
+	plans := []struct {
+		query        *Query
+		lower, upper *Bound
+		estimated    int
+	}{
+		{NewQuery("Time").Between(1, 7).Range(Condition(OpGt, 1)), &Bound{int64(1), false}, &Bound{int64(7), true}, 1},
+		{NewQuery("Old").Range(Condition(OpLt, 7), Condition(OpLe, 7), Condition(OpGe, -2)).Desc(),
+			&Bound{int64(-2), true}, &Bound{int64(7), false}, 1},
+		{NewQuery("Old").Range(Condition(OpGe, 5)), &Bound{int64(5), true}, nil, 2},
+		{NewQuery("Time").Range(Condition(OpGt, 3), Condition(OpLt, 3)), &Bound{int64(3), false},
+			&Bound{int64(3), false}, 0},
+	}
+	for i, test := range plans {
+		plan, err := store.Explain(&coveredRecord{}, test.query)
+		if err != nil {
+			t.Fatalf("plan %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(plan.Lower, test.lower) || !reflect.DeepEqual(plan.Upper, test.upper) ||
+			plan.Estimated != test.estimated {
+			t.Fatalf("plan %d: expected bounds %v, %v estimating %d rows, got %v, %v estimating %d", i, test.lower,
+				test.upper, test.estimated, plan.Lower, plan.Upper, plan.Estimated)
+		}
+	}
+
+	if err := store.Find(&[]coveredRecord{}, NewQuery("Time").Range(Condition(OpNe, 1))); err == nil {
+		t.Fatal("expected an error for a range with OpNe")
+	}
+}
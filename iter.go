@@ -0,0 +1,168 @@
+package meson_bolt_localdb
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrStopIteration can be returned by the function passed to ForEach to stop the iteration without an error
+var ErrStopIteration = errors.New("stop iteration")
+
+// ForEach calls fn with the encoded key and a pointer to the decoded record of each record of dataType matching
+// query, in query order.  The records are read from the cursor as fn is called instead of being collected first,
+// except for Or and OrderBy queries which collect the matching keys.  The iteration stops at the first error
+// returned by fn, which ForEach returns unless it's ErrStopIteration.  key is only valid until fn returns
+func (s *Store) ForEach(dataType interface{}, query *Query, fn func(key []byte, record interface{}) error) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxForEach(tx, dataType, query, fn)
+	})
+}
+
+// TxForEach allows you to pass in your own bolt transaction to iterate over the records matching a query
+func (s *Store) TxForEach(tx *bolt.Tx, dataType interface{}, query *Query,
+	fn func(key []byte, record interface{}) error) error {
+	return s.forEach(tx, dataType, query, fn)
+}
+
+func (s *Store) forEach(source BucketSource, dataType interface{}, query *Query,
+	fn func(key []byte, record interface{}) error) error {
+	err := checkQuery(&query)
+	if err != nil {
+		return err
+	}
+
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
+	mainBkt := source.Bucket([]byte(storer.Type()))
+	if mainBkt == nil {
+		return nil
+	}
+	rType := recordType(dataType)
+
+	return s.walkKeys(source, storer, mainBkt, rType, query, func(key, _ []byte) (bool, error) {
+		record := reflect.New(rType)
+		err := s.decode(mainBkt.Get(key), record.Interface())
+		if err != nil {
+			return false, err
+		}
+		err = s.decodeKey(key, record)
+		if err != nil {
+			return false, err
+		}
+
+		err = fn(key, record.Interface())
+		if err == ErrStopIteration {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// Iter is a pull-style iterator over the records matching a query, returned by Store.Iter:
+//
+//	iter := store.Iter(&FileInfo{}, query)
+//	defer iter.Close()
+//	for iter.Next() {
+//		info := iter.Value().(*FileInfo)
+//	}
+//	if err := iter.Err(); err != nil {
+//		return err
+//	}
+//
+// It holds a read transaction until the records are exhausted or Close is called, so a goroutine must not write
+// to the store while it has an open Iter
+type Iter struct {
+	tx    *bolt.Tx
+	items chan iterItem
+	stop  chan struct{}
+	done  chan struct{}
+
+	key       []byte
+	value     interface{}
+	err       error
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type iterItem struct {
+	key    []byte
+	record interface{}
+}
+
+// Iter returns an iterator over the records of dataType matching query, in query order.  Like ForEach, it reads
+// the records from the cursor as Next is called
+func (s *Store) Iter(dataType interface{}, query *Query) *Iter {
+	iter := &Iter{
+		items: make(chan iterItem),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	tx, err := s.Bolt().Begin(false)
+	if err != nil {
+		iter.err = err
+		close(iter.items)
+		close(iter.done)
+		return iter
+	}
+	iter.tx = tx
+
+	// the transaction is only used by this goroutine until Close rolls it back
+	go func() {
+		defer close(iter.done)
+		defer close(iter.items)
+		iter.err = s.forEach(tx, dataType, query, func(key []byte, record interface{}) error {
+			select {
+			case iter.items <- iterItem{key: append([]byte{}, key...), record: record}:
+				return nil
+			case <-iter.stop:
+				return ErrStopIteration
+			}
+		})
+	}()
+	return iter
+}
+
+// Next moves the iterator to the next record, it returns false when the records are exhausted or an error
+// occurred, and then closes the iterator
+func (i *Iter) Next() bool {
+	item, ok := <-i.items
+	if !ok {
+		i.Close()
+		return false
+	}
+	i.key, i.value = item.key, item.record
+	return true
+}
+
+// Key returns the encoded key of the current record
+func (i *Iter) Key() []byte {
+	return i.key
+}
+
+// Value returns a pointer to the current record
+func (i *Iter) Value() interface{} {
+	return i.value
+}
+
+// Err returns the error which stopped the iteration, once Next has returned false
+func (i *Iter) Err() error {
+	return i.err
+}
+
+// Close stops the iteration and releases the read transaction, it can be called more than once
+func (i *Iter) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.stop)
+		<-i.done
+		if i.tx != nil {
+			i.closeErr = i.tx.Rollback()
+		}
+	})
+	return i.closeErr
+}
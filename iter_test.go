@@ -0,0 +1,115 @@
+package meson_bolt_localdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func iterStore(t *testing.T) *Store {
+	store := openTestStore(t, nil)
+	for i := 0; i < 10; i++ {
+		r := orderedRecord{Group: []string{"a", "b"}[i%2], FileSize: int64(i%4) * 100}
+		if err := store.Insert(i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func Test_forEach(t *testing.T) {
+	store := iterStore(t)
+
+	tests := []struct {
+		query *Query
+		stop  int
+		ids   []int
+	}{
+		{nil, 0, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{nil, 3, []int{0, 1, 2}},
+		{NewQuery("Group").Equal("a").Offset(1).Limit(3), 0, []int{2, 4, 6}},
+		{NewQuery("FileSize").Range(Condition(OpGe, int64(200))).Desc(), 0, []int{3, 7, 2, 6}},
+		{NewQuery("FileSize").In(int64(0), int64(300)).Where("Group", OpEq, "b"), 0, []int{3, 7}},
+		{NewQuery("Group").Equal("b").OrderBy("FileSize", Desc), 2, []int{3, 7}},
+		{NewQuery("Group").Equal("c"), 0, []int{}},
+	}
+	for i, test := range tests {
+		ids := []int{}
+		err := store.ForEach(&orderedRecord{}, test.query, func(key []byte, record interface{}) error {
+			var id int
+			if err := store.decode(key, &id); err != nil {
+				return err
+			}
+			if record.(*orderedRecord).ID != id {
+				t.Fatalf("query %d: record %d doesn't hold its key %d", i, record.(*orderedRecord).ID, id)
+			}
+			ids = append(ids, id)
+			if len(ids) == test.stop {
+				return ErrStopIteration
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Fatalf("query %d: expected records %v, got %v", i, test.ids, ids)
+		}
+	}
+
+	failed := errors.New("failed")
+	calls := 0
+	err := store.ForEach(&orderedRecord{}, nil, func(key []byte, record interface{}) error {
+		calls++
+		return failed
+	})
+	if err != failed || calls != 1 {
+		t.Fatalf("expected the error of the first call, got %v after %d calls", err, calls)
+	}
+}
+
+func Test_iter(t *testing.T) {
+	store := iterStore(t)
+
+	iter := store.Iter(&orderedRecord{}, NewQuery("FileSize").Range(Condition(OpLt, int64(200))))
+	var ids []int
+	for iter.Next() {
+		ids = append(ids, iter.Value().(*orderedRecord).ID)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{0, 4, 8, 1, 5, 9}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected records %v, got %v", expected, ids)
+	}
+	if iter.Next() {
+		t.Fatal("expected an exhausted iterator")
+	}
+
+	// stopping early releases the transaction, so the store can be written again
+	iter = store.Iter(&orderedRecord{}, nil)
+	if !iter.Next() || iter.Value().(*orderedRecord).ID != 0 {
+		t.Fatalf("expected the first record, got %+v", iter.Value())
+	}
+	var key int
+	if err := store.decode(iter.Key(), &key); err != nil || key != 0 {
+		t.Fatalf("expected the key 0, got %d (%v)", key, err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if iter.Next() {
+		t.Fatal("expected a closed iterator")
+	}
+	if err := store.Delete(0, &orderedRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	iter = store.Iter(&orderedRecord{}, NewQuery("Missing").Equal(1))
+	if iter.Next() || iter.Err() == nil {
+		t.Fatal("expected an error for a missing index")
+	}
+}
@@ -0,0 +1,370 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SortOrder is the direction records are sorted in by OrderBy and ThenBy
+type SortOrder int
+
+const (
+	Asc SortOrder = iota
+	Desc
+)
+
+// sortField is a field the records matched by a query are sorted on
+type sortField struct {
+	field string
+	order SortOrder
+}
+
+// OrderBy sorts the records matched by the query on field, which doesn't need to be the query index, or a dotted
+// path to a nested field like "P.Name", in ascending order unless Desc is passed.  Key sorts on the record keys.
+// Records without a value, because of a nil pointer along the path, come last.  OrderBy replaces the order set by
+// Asc and Desc, the limit and offset of the query apply to the sorted records
+func (q *Query) OrderBy(field string, order ...SortOrder) *Query {
+	q.orderBy = []*sortField{newSortField(field, order)}
+	return q
+}
+
+// ThenBy sorts the records holding the same values for the previous OrderBy and ThenBy fields on field.  Records
+// holding the same values for all the fields are sorted on their keys
+func (q *Query) ThenBy(field string, order ...SortOrder) *Query {
+	q.orderBy = append(q.orderBy, newSortField(field, order))
+	return q
+}
+
+func newSortField(field string, order []SortOrder) *sortField {
+	sf := &sortField{field: field, order: Asc}
+	switch len(order) {
+	case 0:
+	case 1:
+		sf.order = order[0]
+	default:
+		// rejected by checkQuery
+		sf.order = -1
+	}
+	return sf
+}
+
+// recordSorter compares the records matched by a query on its sort fields
+type recordSorter struct {
+	fields []*sortField
+	steps  [][]pathStep
+	decode bool // whether a field other than Key is sorted on
+	stats  *queryStats
+	ctx    context.Context
+}
+
+// sortedRow is a matched record with its values for the sort fields, invalid when missing
+type sortedRow struct {
+	key    []byte
+	values []reflect.Value
+}
+
+// newRecordSorter compiles the paths of fields for records of type tp
+func newRecordSorter(tp reflect.Type, fields []*sortField) (*recordSorter, error) {
+	sorter := &recordSorter{fields: fields, steps: make([][]pathStep, len(fields))}
+	for i, f := range fields {
+		if f.field == Key {
+			continue
+		}
+		steps, fieldType, err := compilePath(tp, strings.Split(f.field, "."))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OrderBy field %s: %s", f.field, err)
+		}
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if !orderedType(fieldType) {
+			return nil, fmt.Errorf("OrderBy field %s of type %s can't be sorted", f.field, fieldType)
+		}
+		sorter.steps[i] = steps
+		sorter.decode = true
+	}
+	return sorter, nil
+}
+
+// less tells whether row a sorts before row b, comparing the sort fields from the first one
+func (r *recordSorter) less(a, b *sortedRow, first int) bool {
+	for i := first; i < len(r.fields); i++ {
+		var cmp int
+		if r.fields[i].field == Key {
+			cmp = bytes.Compare(a.key, b.key)
+		} else {
+			av, bv := a.values[i], b.values[i]
+			switch {
+			case !av.IsValid() && !bv.IsValid():
+				continue
+			case !av.IsValid():
+				// missing values come last in both orders
+				return false
+			case !bv.IsValid():
+				return true
+			}
+			cmp, _ = compareValues(av, bv)
+		}
+		if r.fields[i].order == Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+// sortRow decodes the record of key to read its values for the sort fields
+func (s *Store) sortRow(mainBkt *bolt.Bucket, rType reflect.Type, sorter *recordSorter,
+	key []byte) (*sortedRow, error) {
+	if err := ctxErr(sorter.ctx); err != nil {
+		return nil, err
+	}
+	row := &sortedRow{key: key, values: make([]reflect.Value, len(sorter.fields))}
+	if !sorter.decode {
+		return row, nil
+	}
+
+	sorter.stats.add(0, 0, 1)
+	record := reflect.New(rType)
+	err := s.decode(mainBkt.Get(key), record.Interface())
+	if err != nil {
+		return nil, err
+	}
+	err = s.decodeKey(key, record)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range sorter.fields {
+		if f.field == Key {
+			continue
+		}
+		value := reflect.ValueOf(findPathValue(record.Interface(), sorter.steps[i]))
+		if derefValue(&value) {
+			row.values[i] = value
+		}
+	}
+	return row, nil
+}
+
+// rowHeap keeps the first rows in sort order, the last of them on top
+type rowHeap struct {
+	rows   []*sortedRow
+	sorter *recordSorter
+}
+
+func (h *rowHeap) Len() int           { return len(h.rows) }
+func (h *rowHeap) Less(i, j int) bool { return h.sorter.less(h.rows[j], h.rows[i], 0) }
+func (h *rowHeap) Swap(i, j int)      { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+func (h *rowHeap) Push(x interface{}) { h.rows = append(h.rows, x.(*sortedRow)) }
+func (h *rowHeap) Pop() interface{} {
+	last := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return last
+}
+
+// orderedKeys returns the keys of the records matching query sorted on its OrderBy and ThenBy fields.  When the
+// first sort field has an index storing it in order, the index is walked and only the records holding the same
+// value are sorted, otherwise the records are sorted in memory, keeping the first ones in a heap with Limit
+func (s *Store) orderedKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query) (keyList, [][]byte, error) {
+	sorter, err := newRecordSorter(rType, query.orderBy)
+	if err != nil {
+		return nil, nil, err
+	}
+	sorter.stats = query.stats
+	sorter.ctx = query.ctx
+
+	all := *query
+	all.limit = 0
+	all.offset = 0
+	all.orderBy = nil
+	matched, _, err := s.queryKeys(source, storer, mainBkt, rType, &all)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := 0
+	if query.limit > 0 {
+		n = query.offset + query.limit
+	}
+
+	var keys keyList
+	if indexName, index, ok := s.sortIndex(storer, rType, query.orderBy[0]); ok {
+		keys, err = s.indexOrderedKeys(source, storer, mainBkt, rType, sorter, indexName, index,
+			sortKeys(matched), n)
+	} else {
+		keys, err = s.sortedKeys(mainBkt, rType, sorter, matched, n)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if query.offset >= len(keys) {
+		return keyList{}, nil, nil
+	}
+	keys = keys[query.offset:]
+	if query.limit > 0 && query.limit < len(keys) {
+		keys = keys[:query.limit]
+	}
+	return keys, keys, nil
+}
+
+// sortedKeys sorts keys on the sort fields of their records, returning only the first n keys if n > 0
+func (s *Store) sortedKeys(mainBkt *bolt.Bucket, rType reflect.Type, sorter *recordSorter, keys keyList,
+	n int) (keyList, error) {
+	if n > 0 && n < len(keys) {
+		h := &rowHeap{rows: make([]*sortedRow, 0, n), sorter: sorter}
+		for _, k := range keys {
+			row, err := s.sortRow(mainBkt, rType, sorter, k)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case h.Len() < n:
+				heap.Push(h, row)
+			case sorter.less(row, h.rows[0], 0):
+				h.rows[0] = row
+				heap.Fix(h, 0)
+			}
+		}
+		return sortRows(h.rows, sorter, 0), nil
+	}
+
+	rows := make([]*sortedRow, 0, len(keys))
+	for _, k := range keys {
+		row, err := s.sortRow(mainBkt, rType, sorter, k)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return sortRows(rows, sorter, 0), nil
+}
+
+// sortRows sorts rows on the sort fields from the first one and returns their keys
+func sortRows(rows []*sortedRow, sorter *recordSorter, first int) keyList {
+	sort.Slice(rows, func(i, j int) bool {
+		return sorter.less(rows[i], rows[j], first)
+	})
+	keys := make(keyList, len(rows))
+	for i, row := range rows {
+		keys[i] = row.key
+	}
+	return keys
+}
+
+// sortIndex returns an index storing the values of field in their order, which can be walked instead of sorting
+// the records.  The default encoder only keeps the order of signed integers, and only the indexes built from the
+// tags of the type are known to index the field
+func (s *Store) sortIndex(storer Storer, rType reflect.Type, field *sortField) (string, Index, bool) {
+	anon, ok := storer.(*anonStorer)
+	if !ok || !s.defaultEncoder || field.field == Key {
+		return "", Index{}, false
+	}
+	_, fieldType, err := compilePath(rType, strings.Split(field.field, "."))
+	if err != nil {
+		return "", Index{}, false
+	}
+	switch fieldType {
+	case reflect.TypeOf(int(0)), reflect.TypeOf(int8(0)), reflect.TypeOf(int16(0)), reflect.TypeOf(int32(0)),
+		reflect.TypeOf(int64(0)):
+	default:
+		return "", Index{}, false
+	}
+
+	names := make([]string, 0, len(anon.paths))
+	for name := range anon.paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		index := anon.indexes[name]
+		if anon.paths[name] == field.field && index.Collate == "" && !index.OmitZero {
+			return name, index, true
+		}
+	}
+	return "", Index{}, false
+}
+
+// indexOrderedKeys walks the index indexName in the order of the first sort field, collecting the keys of
+// matched, a sorted key list, until n keys are found if n > 0.  The keys holding the same value are sorted on the
+// other sort fields, the keys missing from the index come last
+func (s *Store) indexOrderedKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	sorter *recordSorter, indexName string, index Index, matched keyList, n int) (keyList, error) {
+	keys := make(keyList, 0, len(matched))
+	seen := make(map[string]bool)
+
+	bkt := source.Bucket(indexBucketName(storer.Type(), indexName))
+	if bkt != nil {
+		c := bkt.Cursor()
+		first, next := c.First, c.Next
+		if (sorter.fields[0].order == Desc) != index.Desc {
+			first, next = c.Last, c.Prev
+		}
+
+		for k, v := first(); k != nil && (n == 0 || len(keys) < n); k, v = next() {
+			sorter.stats.add(1, 0, 0)
+			if err := ctxErr(sorter.ctx); err != nil {
+				return nil, err
+			}
+			var indexed keyList
+			err := s.decode(v, &indexed)
+			if err != nil {
+				return nil, err
+			}
+
+			group := make(keyList, 0, len(indexed))
+			for _, key := range indexed {
+				if matched.in(key) {
+					group = append(group, key)
+					seen[string(key)] = true
+				}
+			}
+			if len(group) > 1 && len(sorter.fields) > 1 {
+				group, err = s.sortGroup(mainBkt, rType, sorter, group)
+				if err != nil {
+					return nil, err
+				}
+			}
+			keys = append(keys, group...)
+		}
+	}
+
+	if n > 0 && len(keys) >= n {
+		return keys, nil
+	}
+	var missing keyList
+	for _, key := range matched {
+		if !seen[string(key)] {
+			missing = append(missing, key)
+		}
+	}
+	missing, err := s.sortedKeys(mainBkt, rType, sorter, missing, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, missing...), nil
+}
+
+// sortGroup sorts the keys of records holding the same value for the first sort field on the other ones
+func (s *Store) sortGroup(mainBkt *bolt.Bucket, rType reflect.Type, sorter *recordSorter,
+	keys keyList) (keyList, error) {
+	rows := make([]*sortedRow, 0, len(keys))
+	for _, k := range keys {
+		row, err := s.sortRow(mainBkt, rType, sorter, k)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return sortRows(rows, sorter, 1), nil
+}
@@ -0,0 +1,180 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const pageTokenVersion = 1
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pagePosition is the position of the last record of a page in the query index: the index value, as stored, it
+// was found under and its key
+type pagePosition struct {
+	index   string
+	reverse bool
+	value   []byte
+	key     []byte
+}
+
+// compare returns -1, 0 or 1 when the stored index value comes before, at or after the position in the order the
+// index is walked in
+func (p *pagePosition) compare(value []byte, reverse bool) int {
+	cmp := bytes.Compare(value, p.value)
+	if reverse {
+		return -cmp
+	}
+	return cmp
+}
+
+// token encodes the position into an opaque, URL safe, page token
+func (p *pagePosition) token() string {
+	b := make([]byte, 0, 2+3*binary.MaxVarintLen64+len(p.index)+len(p.value)+len(p.key))
+	b = append(b, pageTokenVersion, 0)
+	if p.reverse {
+		b[1] = 1
+	}
+	var n [binary.MaxVarintLen64]byte
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(p.index)))]...)
+	b = append(b, p.index...)
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(p.value)))]...)
+	b = append(b, p.value...)
+	b = append(b, p.key...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePageToken decodes a token returned by pagePosition.token
+func decodePageToken(token string) (*pagePosition, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 2 || b[0] != pageTokenVersion || b[1] > 1 {
+		return nil, errInvalidPageToken
+	}
+	p := &pagePosition{reverse: b[1] == 1}
+	b = b[2:]
+
+	var fields [2][]byte
+	for i := range fields {
+		size, n := binary.Uvarint(b)
+		if n <= 0 || size > uint64(len(b)-n) {
+			return nil, errInvalidPageToken
+		}
+		fields[i], b = b[n:n+int(size)], b[n+int(size):]
+	}
+	if len(b) == 0 {
+		return nil, errInvalidPageToken
+	}
+	p.index, p.value, p.key = string(fields[0]), fields[1], b
+	return p, nil
+}
+
+// After resumes the query after the last record of the page token was returned with by FindPage.  The next page
+// starts with a seek to that position in the index, so the previous pages aren't walked again and records
+// inserted or deleted meanwhile don't shift the pages.  An empty token starts with the first page.  The query
+// must use the same index and order as the query of the previous page
+func (q *Query) After(token string) *Query {
+	q.after, q.afterErr = nil, nil
+	if token != "" {
+		q.after, q.afterErr = decodePageToken(token)
+	}
+	return q
+}
+
+// FindPage retrieves into result, a pointer to a slice, the page of records matching query, whose Limit is the
+// page size.  It returns the token to pass to After to get the next page, or an empty token after the last page
+func (s *Store) FindPage(result interface{}, query *Query) (string, error) {
+	var token string
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var err error
+		token, err = s.TxFindPage(tx, result, query)
+		return err
+	})
+	return token, err
+}
+
+// TxFindPage allows you to pass in your own bolt transaction to retrieve a page of records
+func (s *Store) TxFindPage(tx *bolt.Tx, result interface{}, query *Query) (string, error) {
+	return s.findPageQuery(tx, result, query)
+}
+
+func (s *Store) findPageQuery(source BucketSource, result interface{}, query *Query) (string, error) {
+	err := checkQuery(&query)
+	if err != nil {
+		return "", err
+	}
+	if query.limit == 0 {
+		return "", errors.New("the page size must be set with Limit")
+	}
+	if len(query.or) > 0 || len(query.orderBy) > 0 {
+		return "", errors.New("pages of Or and OrderBy queries are not supported")
+	}
+
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	sliceVal := resultVal.Elem().Slice(0, 0)
+	resultVal.Elem().Set(sliceVal)
+
+	tp := sliceVal.Type().Elem()
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	storer, err := s.newStorer(reflect.New(tp).Interface())
+	if err != nil {
+		return "", err
+	}
+	mainBkt := source.Bucket([]byte(storer.Type()))
+	if mainBkt == nil {
+		return "", nil
+	}
+
+	// one more record tells whether there is a next page
+	page := *query
+	page.limit++
+	keys, values, err := s.queryKeys(source, storer, mainBkt, tp, &page)
+	if err != nil {
+		return "", err
+	}
+
+	var token string
+	if len(keys) > query.limit {
+		keys = keys[:query.limit]
+		last := len(keys) - 1
+		position := &pagePosition{index: query.index, reverse: query.reverse, value: values[last], key: keys[last]}
+		token = position.token()
+	}
+
+	sliceVal, err = s.appendRecords(query.ctx, sliceVal, keys, tp, mainBkt)
+	if err != nil {
+		return "", err
+	}
+	resultVal.Elem().Set(sliceVal)
+	return token, nil
+}
+
+// checkAfter checks the page position of query was returned for a query on the same index, in the same order
+func checkAfter(query *Query) error {
+	if query.afterErr != nil {
+		return query.afterErr
+	}
+	if query.after == nil {
+		return nil
+	}
+	if len(query.or) > 0 || len(query.orderBy) > 0 {
+		return errors.New("After isn't supported by Or and OrderBy queries")
+	}
+	if query.after.index != query.index {
+		return fmt.Errorf("page token of index [%s] used with index [%s]", query.after.index, query.index)
+	}
+	if query.after.reverse != query.reverse {
+		return errors.New("page token used with a query in the other order")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package meson_bolt_localdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pages returns the keys of all the pages of query
+func pages(t *testing.T, store *Store, query func() *Query) [][]string {
+	t.Helper()
+	var pages [][]string
+	token := ""
+	for {
+		var records []coveredRecord
+		next, err := store.FindPage(&records, query().After(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := []string{}
+		for _, r := range records {
+			page = append(page, r.Hash)
+		}
+		pages = append(pages, page)
+		if next == "" {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatalf("too many pages: %v", pages)
+		}
+		token = next
+	}
+}
+
+func Test_findPage(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query func() *Query
+		pages [][]string
+	}{
+		{func() *Query { return NewQuery("Time").Limit(2) }, [][]string{{"e", "b"}, {"a", "c"}, {"d"}}},
+		{func() *Query { return NewQuery("Time").Limit(3) }, [][]string{{"e", "b", "a"}, {"c", "d"}}},
+		{func() *Query { return NewQuery("Time").Desc().Limit(2) }, [][]string{{"d", "a"}, {"c", "b"}, {"e"}}},
+		{func() *Query { return NewQuery("Old").Limit(3) }, [][]string{{"e", "b", "a"}, {"c", "d"}}},
+		{func() *Query { return NewQuery("Old").Desc().Limit(2) }, [][]string{{"d", "a"}, {"c", "b"}, {"e"}}},
+		{func() *Query { return NewQuery("Old").Range(Condition(OpLt, int64(7))).Desc().Limit(3) },
+			[][]string{{"a", "c", "b"}, {"e"}}},
+		{func() *Query { return NewQuery("Time").Range(Condition(OpGt, int64(-2))).Limit(2) },
+			[][]string{{"b", "a"}, {"c", "d"}}},
+		{func() *Query { return NewQuery("Time").Range(Condition(OpLe, int64(3))).Desc().Limit(1) },
+			[][]string{{"a"}, {"c"}, {"b"}, {"e"}}},
+		{func() *Query { return NewQuery(Key).Limit(2) }, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{func() *Query { return NewQuery(Key).Desc().Limit(4) }, [][]string{{"e", "d", "c", "b"}, {"a"}}},
+		{func() *Query { return NewQuery("Name").Equal("x").Limit(1) }, [][]string{{"a"}, {"c"}}},
+		{func() *Query { return NewQuery("Time").In(int64(7), int64(3)).Limit(2) }, [][]string{{"a", "c"}, {"d"}}},
+		{func() *Query { return NewQuery("Time").Equal(int64(5)).Limit(2) }, [][]string{{}}},
+	}
+	for i, test := range tests {
+		if got := pages(t, store, test.query); !reflect.DeepEqual(got, test.pages) {
+			t.Fatalf("query %d: expected pages %v, got %v", i, test.pages, got)
+		}
+	}
+
+	// the next page starts after the last record returned, whatever changed meanwhile
+	var records []coveredRecord
+	token, err := store.FindPage(&records, NewQuery("Time").Limit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("f", coveredRecord{Time: -5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("b", &coveredRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("g", coveredRecord{Time: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindPage(&records, NewQuery("Time").Limit(2).After(token)); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Hash != "g" || records[1].Hash != "a" {
+		t.Fatalf("expected records g and a, got %+v", records)
+	}
+
+	for _, q := range []*Query{
+		NewQuery("Time"),
+		NewQuery("Name").Limit(2).After(token),
+		NewQuery("Time").Desc().Limit(2).After(token),
+		NewQuery("Time").Limit(2).After("not a token"),
+		NewQuery("Time").Limit(2).After(token).Or(NewQuery(Key).Equal("a")),
+		NewQuery("Time").Limit(2).OrderBy("Name"),
+	} {
+		if _, err := store.FindPage(&records, q); err == nil {
+			t.Fatalf("expected an error for %+v", q)
+		}
+	}
+}
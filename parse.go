@@ -0,0 +1,708 @@
+package meson_bolt_localdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseError is an error in the text of a query parsed by ParseQuery, Offset is the byte offset of the error in
+// the text
+type ParseError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("query error at offset %d: %s", e.Offset, e.Msg)
+}
+
+func errorAt(offset int, format string, args ...interface{}) *ParseError {
+	return &ParseError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string // the unquoted value of strings and quoted identifiers
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "the end of the query"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex splits text into tokens, ending with a tokEOF token
+func lex(text string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(text); {
+		c := text[i]
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i + size
+			for j < len(text) {
+				r, size := utf8.DecodeRuneInString(text[j:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: text[i:j], pos: i})
+			i = j
+		case c == '`':
+			j := strings.IndexByte(text[i+1:], '`')
+			if j < 0 {
+				return nil, errorAt(i, "unterminated quoted field name")
+			}
+			tokens = append(tokens, token{kind: tokQuotedIdent, text: text[i+1 : i+1+j], pos: i})
+			i += j + 2
+		case isDigit(c) || ((c == '-' || c == '+' || c == '.') && i+1 < len(text) && (isDigit(text[i+1]) ||
+			text[i+1] == '.')):
+			j := i + 1
+			for j < len(text) && (isDigit(text[j]) || text[j] == '.' || text[j] == 'e' || text[j] == 'E' ||
+				((text[j] == '-' || text[j] == '+') && (text[j-1] == 'e' || text[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text[i:j], pos: i})
+			i = j
+		case c == '\'' || c == '"':
+			// a quote is escaped by doubling it
+			var value strings.Builder
+			j := i + 1
+			for {
+				if j >= len(text) {
+					return nil, errorAt(i, "unterminated string")
+				}
+				if text[j] == c {
+					if j+1 < len(text) && text[j+1] == c {
+						value.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				value.WriteByte(text[j])
+				j++
+			}
+			tokens = append(tokens, token{kind: tokString, text: value.String(), pos: i})
+			i = j + 1
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := text[i : i+1]
+			if i+1 < len(text) {
+				switch two := text[i : i+2]; two {
+				case "==", "!=", "<>", "<=", ">=":
+					op = two
+				}
+			}
+			if op == "!" {
+				return nil, errorAt(i, "unexpected character '!'")
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		default:
+			return nil, errorAt(i, "unexpected character %q", r)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(text)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// queryKeywords can't be used as field names unless they're quoted with backquotes
+var queryKeywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true,
+	"LIMIT": true, "OFFSET": true, "TRUE": true, "FALSE": true, "NULL": true,
+}
+
+// parsedField is a field of the records named in a query
+type parsedField struct {
+	name    string
+	index   string // the index used for the field, Key for the key of the records
+	indexed bool
+	tp      reflect.Type // nil for the key of records without a key field
+	// nullable is set when the field can be missing because of a nil pointer or a missing map key
+	nullable bool
+	// complete is set when every record is in the index, so it can be walked to sort all the records
+	complete bool
+}
+
+// parsedCondition is a condition of a query, op is one of =, !=, <, <=, >, >=, in and not in
+type parsedCondition struct {
+	field  *parsedField
+	op     string
+	values []interface{}
+}
+
+// parsedOrder is a field of the ORDER BY clause
+type parsedOrder struct {
+	field *parsedField
+	desc  bool
+}
+
+type queryParser struct {
+	tokens []token
+	i      int
+
+	rType    reflect.Type
+	keyName  string                // the key field of the records
+	indexes  map[string]string     // [path]index of the indexes usable for a field
+	complete map[string]bool       // [index] set when all the records are in the index
+	steps    map[string][]pathStep // compiled paths of the fields
+}
+
+// ParseQuery parses a query on the records of dataType written in a small query language, for instance:
+//
+//	LastAccessTime >= 10 AND LastAccessTime <= 20 AND BindName IN ('a', 'b') ORDER BY LastAccessTime DESC LIMIT 10
+//
+// Conditions compare a field, or a dotted path to a nested field, with =, !=, <>, <, <=, > or >=, or test it with
+// IN and NOT IN lists, and are joined by AND.  ORDER BY sorts on fields in ASC or DESC order, LIMIT and OFFSET
+// take an integer.  Key is the key of records without a key field.  Values are numbers, strings quoted with ' or ",
+// true, false and null, converted to the type of the field so they're encoded like the indexed values, times
+// are RFC 3339 strings.  Keywords aren't case sensitive, a field named like a keyword is quoted with backquotes.
+//
+// The first condition on an indexed field, or else the first ORDER BY field if it's indexed, picks the index of
+// the query.  The conditions on other indexed fields become And queries and the others Where filters.
+// The errors in the text are returned as a *ParseError
+func (s *Store) ParseQuery(dataType interface{}, text string) (*Query, error) {
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{
+		tokens:   tokens,
+		rType:    recordType(dataType),
+		indexes:  make(map[string]string),
+		complete: make(map[string]bool),
+		steps:    make(map[string][]pathStep),
+	}
+	if field := s.keyField(p.rType); field != nil {
+		p.keyName = p.rType.Field(field.index).Name
+	}
+
+	names := make([]string, 0, len(storer.Indexes()))
+	for name := range storer.Indexes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	anon, isAnon := storer.(*anonStorer)
+	for _, name := range names {
+		index := storer.Indexes()[name]
+		if index.Collate != "" {
+			// a collated index doesn't compare like the field
+			continue
+		}
+		path := name
+		if isAnon {
+			path = anon.paths[name]
+		}
+		if _, ok := p.indexes[path]; !ok || name == path {
+			// the index named like the field is preferred
+			p.indexes[path] = name
+		}
+		if isAnon && !index.OmitZero && !strings.Contains(path, ".") {
+			field, ok := p.rType.FieldByName(path)
+			p.complete[name] = ok && field.Type.Kind() != reflect.Ptr && field.Type.Kind() != reflect.Interface
+		}
+	}
+
+	conditions, orders, limit, offset, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return p.build(conditions, orders, limit, offset), nil
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// keyword consumes the next token if it's the keyword word
+func (p *queryParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expectKeyword(word string) error {
+	if !p.keyword(word) {
+		return errorAt(p.peek().pos, "expected %s, found %s", word, p.peek())
+	}
+	return nil
+}
+
+func (p *queryParser) parse() ([]*parsedCondition, []*parsedOrder, int, int, error) {
+	var conditions []*parsedCondition
+	var orders []*parsedOrder
+	limit, offset := 0, 0
+
+	t := p.peek()
+	clause := t.kind == tokIdent && (strings.EqualFold(t.text, "ORDER") || strings.EqualFold(t.text, "LIMIT") ||
+		strings.EqualFold(t.text, "OFFSET"))
+	if t.kind != tokEOF && !clause {
+		for {
+			condition, err := p.parseCondition()
+			if err != nil {
+				return nil, nil, 0, 0, err
+			}
+			conditions = append(conditions, condition)
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, nil, 0, 0, err
+		}
+		for {
+			field, err := p.parseField()
+			if err != nil {
+				return nil, nil, 0, 0, err
+			}
+			if field.tp != nil && !orderedType(field.tp) {
+				return nil, nil, 0, 0, errorAt(p.tokens[p.i-1].pos, "field %s of type %s can't be sorted",
+					field.name, field.tp)
+			}
+			order := &parsedOrder{field: field}
+			if p.keyword("DESC") {
+				order.desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			orders = append(orders, order)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	var err error
+	if p.keyword("LIMIT") {
+		if limit, err = p.parseCount("LIMIT"); err != nil {
+			return nil, nil, 0, 0, err
+		}
+	}
+	if p.keyword("OFFSET") {
+		if offset, err = p.parseCount("OFFSET"); err != nil {
+			return nil, nil, 0, 0, err
+		}
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		if t.kind == tokIdent && strings.EqualFold(t.text, "OR") {
+			return nil, nil, 0, 0, errorAt(t.pos, "OR isn't supported, only AND")
+		}
+		return nil, nil, 0, 0, errorAt(t.pos, "expected AND, ORDER BY, LIMIT, OFFSET or the end of the query, "+
+			"found %s", t)
+	}
+	return conditions, orders, limit, offset, nil
+}
+
+// parseCount parses the integer of a LIMIT or OFFSET clause
+func (p *queryParser) parseCount(clause string) (int, error) {
+	t := p.next()
+	if t.kind != tokNumber {
+		return 0, errorAt(t.pos, "expected the integer of %s, found %s", clause, t)
+	}
+	n, err := strconv.Atoi(t.text)
+	if err != nil || n < 0 {
+		return 0, errorAt(t.pos, "invalid %s %s, expected a positive integer", clause, t.text)
+	}
+	return n, nil
+}
+
+// parseField parses and resolves a field name
+func (p *queryParser) parseField() (*parsedField, error) {
+	t := p.next()
+	if t.kind != tokQuotedIdent && (t.kind != tokIdent || queryKeywords[strings.ToUpper(t.text)]) {
+		return nil, errorAt(t.pos, "expected a field name, found %s", t)
+	}
+
+	field := &parsedField{name: t.text}
+	path := strings.Split(t.text, ".")
+	steps, tp, err := compilePath(p.rType, path)
+	if err != nil {
+		if t.text == "Key" {
+			// the key of records without a key field
+			field.name, field.index, field.indexed = Key, Key, true
+			return field, nil
+		}
+		return nil, errorAt(t.pos, "unknown field %s: %s", t.text, err)
+	}
+	for i := range path {
+		// a nil pointer or a missing map key along the path is a null value
+		_, prefix, _ := compilePath(p.rType, path[:i+1])
+		switch prefix.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map:
+			field.nullable = field.nullable || i < len(path)-1 || prefix.Kind() != reflect.Map
+		}
+	}
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	field.tp = tp
+	p.steps[t.text] = steps
+
+	if t.text == p.keyName {
+		field.index, field.indexed = Key, true
+	} else if index, ok := p.indexes[t.text]; ok {
+		field.index, field.indexed, field.complete = index, true, p.complete[index]
+	}
+	return field, nil
+}
+
+func (p *queryParser) parseCondition() (*parsedCondition, error) {
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	condition := &parsedCondition{field: field}
+
+	t := p.peek()
+	switch {
+	case p.keyword("NOT"):
+		if err := p.expectKeyword("IN"); err != nil {
+			return nil, err
+		}
+		condition.op = "not in"
+	case p.keyword("IN"):
+		condition.op = "in"
+	case t.kind == tokOp:
+		p.next()
+		condition.op = t.text
+		switch t.text {
+		case "==":
+			condition.op = "="
+		case "<>":
+			condition.op = "!="
+		}
+		if condition.op != "=" && condition.op != "!=" && field.tp != nil && !orderedType(field.tp) {
+			return nil, errorAt(t.pos, "field %s of type %s can only be compared with = or !=", field.name,
+				field.tp)
+		}
+	default:
+		return nil, errorAt(t.pos, "expected a comparison operator, IN or NOT IN after %s, found %s", field.name, t)
+	}
+
+	if condition.op != "in" && condition.op != "not in" {
+		value, err := p.parseValue(field, condition.op == "=" || condition.op == "!=")
+		if err != nil {
+			return nil, err
+		}
+		condition.values = []interface{}{value}
+		return condition, nil
+	}
+
+	if t := p.next(); t.kind != tokLParen {
+		return nil, errorAt(t.pos, "expected ( starting the list of values, found %s", t)
+	}
+	for {
+		value, err := p.parseValue(field, false)
+		if err != nil {
+			return nil, err
+		}
+		condition.values = append(condition.values, value)
+		t := p.next()
+		if t.kind == tokRParen {
+			return condition, nil
+		}
+		if t.kind != tokComma {
+			return nil, errorAt(t.pos, "expected , or ) in the list of values, found %s", t)
+		}
+	}
+}
+
+// parseValue parses a value compared to field and converts it to the type of field
+func (p *queryParser) parseValue(field *parsedField, nullable bool) (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokIdent && strings.EqualFold(t.text, "NULL"):
+		if !nullable || !field.nullable {
+			return nil, errorAt(t.pos, "null can only be compared with = or != to a field which can be missing")
+		}
+		return nil, nil
+	case t.kind == tokIdent && (strings.EqualFold(t.text, "TRUE") || strings.EqualFold(t.text, "FALSE")):
+		t.text = strings.ToLower(t.text)
+	case t.kind != tokNumber && t.kind != tokString:
+		return nil, errorAt(t.pos, "expected a value, found %s", t)
+	}
+
+	value, err := coerceValue(t, field.tp)
+	if err != nil {
+		return nil, errorAt(t.pos, "invalid value %s for field %s: %s", t, field.name, err)
+	}
+	return value, nil
+}
+
+// coerceValue converts the value of t to tp.  Without a type, integers are converted to int, the type of untyped
+// Go integer constants
+func coerceValue(t token, tp reflect.Type) (interface{}, error) {
+	isBool := t.kind == tokIdent
+	if tp == nil {
+		switch {
+		case isBool:
+			return t.text == "true", nil
+		case t.kind == tokString:
+			return t.text, nil
+		}
+		if i, err := strconv.ParseInt(t.text, 10, 0); err == nil {
+			return int(i), nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number")
+		}
+		return f, nil
+	}
+
+	if tp == timeType {
+		if t.kind != tokString {
+			return nil, fmt.Errorf("expected a time as an RFC 3339 string")
+		}
+		tm, err := time.Parse(time.RFC3339Nano, t.text)
+		if err != nil {
+			return nil, fmt.Errorf("expected a time as an RFC 3339 string")
+		}
+		return tm, nil
+	}
+
+	var value reflect.Value
+	switch tp.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t.kind != tokNumber {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		i, err := strconv.ParseInt(t.text, 10, tp.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer of %d bits", tp.Bits())
+		}
+		value = reflect.ValueOf(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if t.kind != tokNumber {
+			return nil, fmt.Errorf("expected a positive integer")
+		}
+		u, err := strconv.ParseUint(t.text, 10, tp.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("expected a positive integer of %d bits", tp.Bits())
+		}
+		value = reflect.ValueOf(u)
+	case reflect.Float32, reflect.Float64:
+		if t.kind != tokNumber {
+			return nil, fmt.Errorf("expected a number")
+		}
+		f, err := strconv.ParseFloat(t.text, tp.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("expected a number")
+		}
+		value = reflect.ValueOf(f)
+	case reflect.String:
+		if t.kind != tokString {
+			return nil, fmt.Errorf("expected a string")
+		}
+		value = reflect.ValueOf(t.text)
+	case reflect.Bool:
+		if !isBool {
+			return nil, fmt.Errorf("expected true or false")
+		}
+		value = reflect.ValueOf(t.text == "true")
+	default:
+		return nil, fmt.Errorf("fields of type %s can't be compared", tp)
+	}
+	return value.Convert(tp).Interface(), nil
+}
+
+// indexedQuery is the query of an index built from the conditions on its field
+type indexedQuery struct {
+	query     *Query
+	equal, in bool
+	bounded   bool
+}
+
+// add adds the criterion of condition to the query, or returns false if it can't be combined with the others
+func (q *indexedQuery) add(condition *parsedCondition) bool {
+	switch condition.op {
+	case "=":
+		if q.equal || q.in || q.bounded {
+			return false
+		}
+		q.query.Equal(condition.values[0])
+		q.equal = true
+	case "in":
+		if q.equal || q.in || q.bounded {
+			return false
+		}
+		q.query.In(condition.values...)
+		q.in = true
+	case ">", ">=", "<", "<=":
+		if q.equal || q.in {
+			return false
+		}
+		// the range keeps the tightest bounds
+		q.query.Range(Condition(queryOperators[condition.op], condition.values[0]))
+		q.bounded = true
+	default:
+		return false
+	}
+	return true
+}
+
+var queryOperators = map[string]Operator{
+	"=": OpEq, "!=": OpNe, ">": OpGt, ">=": OpGe, "<": OpLt, "<=": OpLe,
+}
+
+// indexable tells whether condition can be a criterion of the query of an index
+func indexable(condition *parsedCondition) bool {
+	if !condition.field.indexed || condition.op == "!=" || condition.op == "not in" {
+		return false
+	}
+	for _, v := range condition.values {
+		if v == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// build builds the query of the parsed clauses
+func (p *queryParser) build(conditions []*parsedCondition, orders []*parsedOrder, limit, offset int) *Query {
+	main, found := Key, false
+	for _, condition := range conditions {
+		if indexable(condition) {
+			main, found = condition.field.index, true
+			break
+		}
+	}
+	if !found && len(orders) > 0 && orders[0].field.indexed && (orders[0].field.index == Key ||
+		orders[0].field.complete) {
+		main, found = orders[0].field.index, true
+	}
+
+	query := NewQuery(main)
+	queries := map[string]*indexedQuery{main: {query: query}}
+	var and []*Query
+	for _, condition := range conditions {
+		field := condition.field
+		if indexable(condition) {
+			q, ok := queries[field.index]
+			if !ok {
+				q = &indexedQuery{query: NewQuery(field.index)}
+				queries[field.index] = q
+				and = append(and, q.query)
+			}
+			if q.add(condition) {
+				continue
+			}
+		}
+
+		switch {
+		case field.indexed && field.index == main && (condition.op == "not in" ||
+			condition.op == "!=" && condition.values[0] != nil):
+			query.NotIn(condition.values...)
+		case field.tp == nil:
+			// the key of records without a key field can only be queried through its own query
+			q := &indexedQuery{query: NewQuery(Key)}
+			if !q.add(condition) {
+				q.query.NotIn(condition.values...)
+			}
+			and = append(and, q.query)
+		case condition.op == "in":
+			steps, values := p.steps[field.name], condition.values
+			query.Filter(func(record interface{}) bool {
+				value := findPathValue(record, steps)
+				for _, v := range values {
+					if matchWhere(value, OpEq, v) {
+						return true
+					}
+				}
+				return false
+			})
+		case condition.op == "not in":
+			for _, v := range condition.values {
+				query.Where(field.name, OpNe, v)
+			}
+		default:
+			query.Where(field.name, queryOperators[condition.op], condition.values[0])
+		}
+	}
+	if len(and) > 0 {
+		query.And(and...)
+	}
+
+	if len(orders) == 1 && orders[0].field.indexed && orders[0].field.index == main {
+		if orders[0].desc {
+			query.Desc()
+		}
+	} else {
+		for i, order := range orders {
+			sortOrder := Asc
+			if order.desc {
+				sortOrder = Desc
+			}
+			if i == 0 {
+				query.OrderBy(order.field.name, sortOrder)
+			} else {
+				query.ThenBy(order.field.name, sortOrder)
+			}
+		}
+	}
+
+	query.Limit(limit)
+	query.Offset(offset)
+	return query
+}
@@ -0,0 +1,150 @@
+package meson_bolt_localdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseQuery(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		text  string
+		index string
+		keys  []string
+	}{
+		{"", Key, []string{"a", "b", "c", "d", "e"}},
+		{"Time = 3", "Time", []string{"a", "c"}},
+		{"Time >= 1 AND Time < 7", "Time", []string{"b", "a", "c"}},
+		{"Time >= 1 and Time < 7 order by Time desc", "Time", []string{"a", "c", "b"}},
+		{"Time IN (7, -2)", "Time", []string{"e", "d"}},
+		{"Time NOT IN (3) ORDER BY Time", "Time", []string{"e", "b", "d"}},
+		{"Time > 0 AND Time <> 3", "Time", []string{"b", "d"}},
+		{"Time > 0 AND Time > 2 AND Time < 7", "Time", []string{"a", "c"}},
+		{"Hash >= 'c'", Key, []string{"c", "d", "e"}},
+		{"Name = 'x' AND Time = 3", "Time", []string{"c"}},
+		{"Name IN ('X', 'y')", Key, []string{"a", "b"}},
+		{"Name NOT IN ('X', 'y') AND Hash != 'e'", Key, []string{"c", "d"}},
+		{"Name > 'x' ORDER BY Name DESC, Hash", Key, []string{"e", "b"}},
+		{"ORDER BY Time DESC LIMIT 2 OFFSET 1", "Time", []string{"a", "c"}},
+		{"Time >= 3 ORDER BY Hash DESC LIMIT 2", "Time", []string{"d", "c"}},
+	}
+	for i, test := range tests {
+		query, err := store.ParseQuery(coveredRecord{}, test.text)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if query.index != test.index {
+			t.Fatalf("query %d: expected index %q, got %q", i, test.index, query.index)
+		}
+		var keys []string
+		if err := store.FindKeys(&coveredRecord{}, &keys, query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+	}
+
+	query, err := store.ParseQuery(coveredRecord{}, "Time = 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := query.equalCriteria.value.(int64); !ok {
+		t.Fatalf("expected an int64 value, got %T", query.equalCriteria.value)
+	}
+
+	errors := []struct {
+		text   string
+		offset int
+	}{
+		{"Time = 3 OR Time = 4", 9},
+		{"Time = 'a'", 7},
+		{"Time = 99999999999999999999", 7},
+		{"Size = 3", 0},
+		{"Time = 3 AND", 12},
+		{"Time 3", 5},
+		{"Time IN (1, 2", 13},
+		{"Time IN 1", 8},
+		{"Name = 'x", 7},
+		{"Time ! 3", 5},
+		{"Time = 3 LIMIT -1", 15},
+		{"Time = 3 ORDER Time", 15},
+		{"Time = null", 7},
+		{"ORDER BY Time LIMIT 2 Time", 22},
+		{"Time # 3", 5},
+	}
+	for i, test := range errors {
+		_, err := store.ParseQuery(coveredRecord{}, test.text)
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("error %d: expected a parse error, got %v", i, err)
+		}
+		if parseErr.Offset != test.offset {
+			t.Fatalf("error %d: expected offset %d, got %d: %s", i, test.offset, parseErr.Offset, err)
+		}
+		if !strings.HasPrefix(err.Error(), fmt.Sprintf("query error at offset %d:", test.offset)) {
+			t.Fatalf("error %d: unexpected message %q", i, err)
+		}
+	}
+}
+
+func Test_parseQueryTypes(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		r := filteredRecord{
+			Group:    []string{"a", "b"}[i%2],
+			FileSize: int64(i * 50),
+			Rate:     float32(i) / 2,
+			Created:  base.Add(time.Duration(i) * time.Hour),
+		}
+		if i%3 == 0 {
+			r.Owner = &filteredOwner{Name: "bob"}
+		}
+		if err := store.Insert(i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		text string
+		ids  []int
+	}{
+		{"ID >= 4", []int{4, 5}},
+		{"Group = 'b' AND FileSize > 50", []int{3, 5}},
+		{"Rate = 1.5", []int{3}},
+		{"Owner = null", []int{1, 2, 4, 5}},
+		{"Owner.Name != NULL AND Group = 'a'", []int{0}},
+		{"Created >= '2021-01-01T04:00:00Z'", []int{4, 5}},
+		{"Group IN ('b') ORDER BY Rate DESC LIMIT 2", []int{5, 3}},
+		{"ORDER BY Group DESC, ID", []int{1, 3, 5, 0, 2, 4}},
+	}
+	for i, test := range tests {
+		query, err := store.ParseQuery(&filteredRecord{}, test.text)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		var records []filteredRecord
+		if err := store.Find(&records, query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		ids := make([]int, len(records))
+		for j := range records {
+			ids[j] = records[j].ID
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Fatalf("query %d: expected records %v, got %v", i, test.ids, ids)
+		}
+	}
+
+	for _, text := range []string{"Rate = 'a'", "Created = 3", "Tags > 1", "ORDER BY Tags", "FileSize = null"} {
+		if _, err := store.ParseQuery(&filteredRecord{}, text); err == nil {
+			t.Fatalf("expected an error for %s", text)
+		}
+	}
+}
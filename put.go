@@ -2,6 +2,7 @@ package meson_bolt_localdb
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 
 	bolt "go.etcd.io/bbolt"
@@ -13,6 +14,60 @@ var ErrKeyExists = errors.New("This Key already exists in this bolthold for this
 // ErrUniqueExists is the error thrown when data is being inserted for a unique constraint value that already exists
 var ErrUniqueExists = errors.New("This value cannot be written due to the unique constraint on the field")
 
+// KeyExistsError is returned by Insert when the key is already used by another record of the same type.
+// It matches ErrKeyExists with errors.Is
+type KeyExistsError struct {
+	TypeName string
+	Key      []byte // encoded key
+
+	decode DecodeFunc
+}
+
+func (e *KeyExistsError) Error() string {
+	return fmt.Sprintf("%s (type: %s)", ErrKeyExists.Error(), e.TypeName)
+}
+
+// Is reports whether target is ErrKeyExists
+func (e *KeyExistsError) Is(target error) bool {
+	return target == ErrKeyExists
+}
+
+// KeyAs decodes the conflicting key into result, which must be a pointer
+func (e *KeyExistsError) KeyAs(result interface{}) error {
+	return e.decode(e.Key, result)
+}
+
+// UniqueExistsError is returned when a write would break a unique index. It carries the index that was
+// violated, the conflicting value and the key of the record which already owns that value.
+// It matches ErrUniqueExists with errors.Is
+type UniqueExistsError struct {
+	TypeName    string
+	IndexName   string
+	Value       []byte // encoded index value
+	ExistingKey []byte // encoded key of the record already holding Value
+
+	decode DecodeFunc
+}
+
+func (e *UniqueExistsError) Error() string {
+	return fmt.Sprintf("%s (type: %s, index: %s)", ErrUniqueExists.Error(), e.TypeName, e.IndexName)
+}
+
+// Is reports whether target is ErrUniqueExists
+func (e *UniqueExistsError) Is(target error) bool {
+	return target == ErrUniqueExists
+}
+
+// ValueAs decodes the conflicting index value into result, which must be a pointer
+func (e *UniqueExistsError) ValueAs(result interface{}) error {
+	return e.decode(e.Value, result)
+}
+
+// ExistingKeyAs decodes the key of the record already holding the value into result, which must be a pointer
+func (e *UniqueExistsError) ExistingKeyAs(result interface{}) error {
+	return e.decode(e.ExistingKey, result)
+}
+
 // sequence tells bolthold to insert the key as the next sequence in the bucket
 type sequence struct{}
 
@@ -25,7 +80,8 @@ func NextSequence() interface{} {
 
 // Insert inserts the passed in data into the the bolthold
 //
-// If the the key already exists in the bolthold, then an ErrKeyExists is returned
+// If the the key already exists in the bolthold, then a *KeyExistsError matching ErrKeyExists is returned
+// If a unique index value is already used, then a *UniqueExistsError matching ErrUniqueExists is returned
 // If the data struct has a field tagged as `boltholdKey` and it is the same type
 // as the Insert key, AND the data struct is passed by reference, AND the key field
 // is currently set to the zero-value for that type, then that field will be set to
@@ -55,7 +111,10 @@ func (s *Store) TxInsert(tx *bolt.Tx, key, data interface{}) error {
 //}
 
 func (s *Store) insert(source BucketSource, key, data interface{}) error {
-	storer := s.newStorer(data)
+	storer, err := s.newStorer(data)
+	if err != nil {
+		return err
+	}
 
 	b, err := source.CreateBucketIfNotExists([]byte(storer.Type()))
 	if err != nil {
@@ -75,8 +134,20 @@ func (s *Store) insert(source BucketSource, key, data interface{}) error {
 		return err
 	}
 
-	if b.Get(gk) != nil {
-		return ErrKeyExists
+	if existing := b.Get(gk); existing != nil {
+		if !s.expired(source, storer, gk) {
+			return &KeyExistsError{TypeName: storer.Type(), Key: gk, decode: s.decode}
+		}
+		// replace the expired record
+		existingVal := newElemType(data)
+		err = s.decode(existing, existingVal)
+		if err != nil {
+			return err
+		}
+		err = s.deleteIndexes(storer, source, gk, existingVal)
+		if err != nil {
+			return err
+		}
 	}
 
 	value, err := s.encode(data)
@@ -97,32 +168,29 @@ func (s *Store) insert(source BucketSource, key, data interface{}) error {
 		return err
 	}
 
-	dataVal := reflect.Indirect(reflect.ValueOf(data))
-	if !dataVal.CanSet() {
+	var fieldValue reflect.Value
+	if accessor, ok := keyAccessor(reflect.ValueOf(data)); ok {
+		fieldValue = reflect.ValueOf(accessor.BoltholdKey()).Elem()
+	} else {
+		dataVal := reflect.Indirect(reflect.ValueOf(data))
+		if !dataVal.CanSet() {
+			return nil
+		}
+		field := s.keyField(dataVal.Type())
+		if field == nil {
+			return nil
+		}
+		fieldValue = dataVal.Field(field.index)
+	}
+
+	keyValue := reflect.ValueOf(key)
+	if keyValue.Type() != fieldValue.Type() || !fieldValue.CanSet() {
 		return nil
 	}
-	dataType := dataVal.Type()
-
-	for i := 0; i < dataType.NumField(); i++ {
-		tf := dataType.Field(i)
-		// XXX: should we require standard tag format so we can use StructTag.Lookup()?
-		// XXX: should we use strings.Contains(string(tf.Tag), BoltholdKeyTag) so we don't require proper tags?
-		if _, ok := tf.Tag.Lookup(BoltholdKeyTag); ok {
-			fieldValue := dataVal.Field(i)
-			keyValue := reflect.ValueOf(key)
-			if keyValue.Type() != tf.Type {
-				break
-			}
-			if !fieldValue.CanSet() {
-				break
-			}
-			if !reflect.DeepEqual(fieldValue.Interface(), reflect.Zero(tf.Type).Interface()) {
-				break
-			}
-			fieldValue.Set(keyValue)
-			break
-		}
+	if !fieldValue.IsZero() {
+		return nil
 	}
+	fieldValue.Set(keyValue)
 
 	return nil
 }
@@ -153,7 +221,10 @@ func (s *Store) TxUpdate(tx *bolt.Tx, key interface{}, data interface{}) error {
 //}
 
 func (s *Store) update(source BucketSource, key interface{}, data interface{}) error {
-	storer := s.newStorer(data)
+	storer, err := s.newStorer(data)
+	if err != nil {
+		return err
+	}
 
 	gk, err := s.encode(key)
 
@@ -168,7 +239,7 @@ func (s *Store) update(source BucketSource, key interface{}, data interface{}) e
 
 	existing := b.Get(gk)
 
-	if existing == nil {
+	if existing == nil || s.expired(source, storer, gk) {
 		return ErrNotFound
 	}
 
@@ -225,7 +296,10 @@ func (s *Store) TxUpsert(tx *bolt.Tx, key interface{}, data interface{}) error {
 //}
 
 func (s *Store) upsert(source BucketSource, key interface{}, data interface{}) error {
-	storer := s.newStorer(data)
+	storer, err := s.newStorer(data)
+	if err != nil {
+		return err
+	}
 
 	gk, err := s.encode(key)
 
@@ -240,6 +314,7 @@ func (s *Store) upsert(source BucketSource, key interface{}, data interface{}) e
 
 	existing := b.Get(gk)
 
+	// an expired record is replaced like a missing one, its indexes are removed the same way
 	if existing != nil {
 		existingVal := newElemType(data)
 
@@ -0,0 +1,102 @@
+package meson_bolt_localdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T, options *Options) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "test.db"), 0666, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
+type uniqueRecord struct {
+	Name string `boltholdIndex:"Name"`
+	No   uint64 `boltholdUnique:"No"`
+}
+
+func Test_insertErrors(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	err := store.Insert("a", uniqueRecord{"aaa", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Insert("a", uniqueRecord{"bbb", 2})
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	var keyErr *KeyExistsError
+	if !errors.As(err, &keyErr) {
+		t.Fatalf("expected *KeyExistsError, got %T", err)
+	}
+	var key string
+	if err := keyErr.KeyAs(&key); err != nil || key != "a" || keyErr.TypeName != "uniqueRecord" {
+		t.Fatalf("unexpected key error content: %v %q %q", err, key, keyErr.TypeName)
+	}
+
+	err = store.Insert("b", uniqueRecord{"ccc", 1})
+	if !errors.Is(err, ErrUniqueExists) {
+		t.Fatalf("expected ErrUniqueExists, got %v", err)
+	}
+	var uniqueErr *UniqueExistsError
+	if !errors.As(err, &uniqueErr) {
+		t.Fatalf("expected *UniqueExistsError, got %T", err)
+	}
+	if uniqueErr.TypeName != "uniqueRecord" || uniqueErr.IndexName != "No" {
+		t.Fatalf("unexpected unique error content: %+v", uniqueErr)
+	}
+	var no uint64
+	if err := uniqueErr.ValueAs(&no); err != nil || no != 1 {
+		t.Fatalf("unexpected conflicting value: %v %d", err, no)
+	}
+	if err := uniqueErr.ExistingKeyAs(&key); err != nil || key != "a" {
+		t.Fatalf("unexpected existing key: %v %q", err, key)
+	}
+}
+
+type optionalUniqueRecord struct {
+	ExternalID string `boltholdUnique:"ExternalID,ignorezero"`
+	Serial     string `boltholdUnique:"Serial,omitzero"`
+}
+
+func Test_uniqueIgnoreZero(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	records := []optionalUniqueRecord{
+		{ExternalID: "", Serial: ""},
+		{ExternalID: "", Serial: ""},
+		{ExternalID: "ext-1", Serial: "s-1"},
+	}
+	for i, r := range records {
+		if err := store.Insert(i, r); err != nil {
+			t.Fatalf("insert %d: %s", i, err)
+		}
+	}
+
+	if err := store.Insert(10, optionalUniqueRecord{ExternalID: "ext-1"}); !errors.Is(err, ErrUniqueExists) {
+		t.Fatalf("expected ErrUniqueExists, got %v", err)
+	}
+	if err := store.Insert(11, optionalUniqueRecord{Serial: "s-1"}); !errors.Is(err, ErrUniqueExists) {
+		t.Fatalf("expected ErrUniqueExists, got %v", err)
+	}
+
+	// zero values are still indexed with ignorezero, but not with omitzero
+	count, err := store.Count(&optionalUniqueRecord{}, NewQuery("ExternalID").Equal(""))
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 records with an empty ExternalID, got %d (%v)", count, err)
+	}
+	count, err = store.Count(&optionalUniqueRecord{}, NewQuery("Serial"))
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 indexed Serial, got %d (%v)", count, err)
+	}
+}
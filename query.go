@@ -2,10 +2,14 @@ package meson_bolt_localdb
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/daqnext/meson-bolt-localdb/internal/fieldtag"
 	bolt "go.etcd.io/bbolt"
+	"math"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -17,19 +21,25 @@ const (
 	OpGe
 	OpLt
 	OpLe
+	// OpNe is only supported by Where
+	OpNe
 )
 
+// OpEq compares for equality in Where, Equal does the same on the query index
+const OpEq = opEq
+
 type QueryType int
 
 const QueryRange QueryType = 1
 const QueryEqual QueryType = 2
+const QueryIn QueryType = 3
 
 // Key is shorthand for specifying a query to run again the Key in a bolthold, simply returns ""
 // Where(bolthold.Key).Eq("testkey")
 const Key = ""
 
 // BoltholdKeyTag is the struct tag used to define an a field as a key for use in a Find query
-const BoltholdKeyTag = "boltholdKey"
+const BoltholdKeyTag = fieldtag.BoltholdKey
 
 type Criterion struct {
 	op    Operator
@@ -37,15 +47,27 @@ type Criterion struct {
 }
 
 type Query struct {
-	index      string
-	limit      int
-	offset     int
-	reverse    bool
-	excludeKey [][]byte
+	index       string
+	limit       int
+	offset      int
+	reverse     bool
+	notIn       []interface{}
+	excludeKeys []interface{}
+	and         []*Query
+	or          []*Query
+	where       []*whereCriterion
+	filters     []func(record interface{}) bool
+	orderBy     []*sortField
+	after       *pagePosition
+	afterErr    error
+	stats       *queryStats     // set by Explain
+	within      *keyList        // the keys found by the And queries, set while the query is walked
+	ctx         context.Context // set by the Ctx functions
 
 	queryType     QueryType
 	rangeCriteria []*Criterion
 	equalCriteria *Criterion
+	inCriteria    []interface{}
 }
 
 func NewQuery(index string) *Query {
@@ -69,16 +91,50 @@ func (q *Query) Equal(value interface{}) *Query {
 	return q
 }
 
+// In matches the records whose index value is one of values, in index order
+func (q *Query) In(values ...interface{}) *Query {
+	q.queryType = QueryIn
+	q.inCriteria = append(q.inCriteria, values...)
+	return q
+}
+
+// NotIn skips the records whose index value is one of values, it can be combined with Range, Equal and In
+func (q *Query) NotIn(values ...interface{}) *Query {
+	q.notIn = append(q.notIn, values...)
+	return q
+}
+
+// Exclude skips the records whose index value, or key for a Key query, is one of value.  It's ExcludeValues,
+// kept for compatibility
 func (q *Query) Exclude(value ...interface{}) *Query {
-	if q.excludeKey == nil {
-		q.excludeKey = [][]byte{}
-	}
-	for _, v := range value {
-		key, err := DefaultEncode(v)
-		if err == nil {
-			q.excludeKey = append(q.excludeKey, key)
-		}
-	}
+	return q.ExcludeValues(value...)
+}
+
+// ExcludeValues skips the records whose index value, or key for a Key query, is one of values, encoded by the
+// Encoder of the store like the index values.  It's the same as NotIn
+func (q *Query) ExcludeValues(values ...interface{}) *Query {
+	return q.NotIn(values...)
+}
+
+// ExcludeKeys skips the records whose key is one of keys, whatever the index of the query
+func (q *Query) ExcludeKeys(keys ...interface{}) *Query {
+	q.excludeKeys = append(q.excludeKeys, keys...)
+	return q
+}
+
+// And restricts the query to the records also matching all of queries, each one on its own index or the Key.
+// The records are still returned in the order of q, the limit and offset of queries are ignored
+func (q *Query) And(queries ...*Query) *Query {
+	q.and = append(q.and, queries...)
+	return q
+}
+
+// Or adds the records matching any of queries, each one on its own index or the Key, to the records matching q.
+// The records are returned once, in the order of q, which the limit and offset of q apply to, and must pass the
+// NotIn values, excluded keys, Where and Filter predicates of q.  The records not in the index of q come last.
+// The limit and offset of queries are ignored
+func (q *Query) Or(queries ...*Query) *Query {
+	q.or = append(q.or, queries...)
 	return q
 }
 
@@ -112,8 +168,21 @@ func checkQuery(q **Query) error {
 		(*q).queryType = QueryRange
 	}
 
-	if (*q).queryType != QueryEqual && (*q).queryType != QueryRange {
-		return errors.New("query type error, only Range or Equal supported")
+	if (*q).queryType != QueryEqual && (*q).queryType != QueryRange && (*q).queryType != QueryIn {
+		return errors.New("query type error, only Range, Equal or In supported")
+	}
+
+	if (*q).queryType == QueryIn {
+		for _, v := range (*q).inCriteria {
+			if v == nil {
+				return errors.New("in Criteria value is nil")
+			}
+		}
+	}
+	for _, v := range (*q).notIn {
+		if v == nil {
+			return errors.New("not in Criteria value is nil")
+		}
 	}
 
 	if (*q).queryType == QueryEqual {
@@ -128,38 +197,14 @@ func checkQuery(q **Query) error {
 	}
 
 	if (*q).queryType == QueryRange {
-		if len((*q).rangeCriteria) > 2 {
-			return errors.New("range condition error,max condition count is 2")
-		}
-
-		var minValue, maxValue []byte
-		var err error
 		for _, v := range (*q).rangeCriteria {
-			if v.value == nil {
+			if v == nil || v.value == nil {
 				return errors.New("range Criteria value is nil")
 			}
-
-			switch v.op {
-			case OpGe, OpGt:
-				minValue, err = DefaultEncode(v.value)
-				if err != nil {
-					return err
-				}
-			case OpLe, OpLt:
-				maxValue, err = DefaultEncode(v.value)
-				if err != nil {
-					return err
-				}
-			}
-
-		}
-
-		if minValue != nil && maxValue != nil {
-			if bytes.Compare(minValue, maxValue) > 0 {
-				return errors.New("range Criteria value range error")
+			if v.op != OpGt && v.op != OpGe && v.op != OpLt && v.op != OpLe {
+				return errors.New("range Criteria operator must be OpGt, OpGe, OpLt or OpLe")
 			}
 		}
-
 	}
 
 	if (*q).limit < 0 {
@@ -170,6 +215,47 @@ func checkQuery(q **Query) error {
 		return errors.New("offset error")
 	}
 
+	for _, where := range (*q).where {
+		if where.field == "" {
+			return errors.New("where field is empty")
+		}
+		if where.op < opEq || where.op > OpNe {
+			return errors.New("where operator is unknown")
+		}
+	}
+	for _, filter := range (*q).filters {
+		if filter == nil {
+			return errors.New("filter is nil")
+		}
+	}
+	if err := checkAfter(*q); err != nil {
+		return err
+	}
+	for _, field := range (*q).orderBy {
+		if field.order != Asc && field.order != Desc {
+			return errors.New("sort order must be Asc or Desc")
+		}
+	}
+
+	for _, and := range (*q).and {
+		if and == nil {
+			return errors.New("and query is nil")
+		}
+		err := checkQuery(&and)
+		if err != nil {
+			return err
+		}
+	}
+	for _, or := range (*q).or {
+		if or == nil {
+			return errors.New("or query is nil")
+		}
+		err := checkQuery(&or)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -181,13 +267,42 @@ func Condition(op Operator, value interface{}) *Criterion {
 }
 
 func (s *Store) findOneQuery(source BucketSource, result interface{}, query *Query) error {
-	if query == nil {
-		query = &Query{}
-		query.queryType = QueryRange
-		//return errors.New("nil query condition")
+	err := checkQuery(&query)
+	if err != nil {
+		return err
+	}
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.IsNil() {
+		panic("result argument must be an address")
+	}
+	one := *query
+	one.limit = 1
+	if resultVal.Elem().Kind() == reflect.Slice {
+		// kept for compatibility, the record is returned in the slice
+		return s.findQuery(source, result, &one)
+	}
+
+	tp := resultVal.Elem().Type()
+	found := false
+	err = s.runQuery(source, result, tp, &one, func(keys keyList, tp reflect.Type, bkt *bolt.Bucket) error {
+		if len(keys) == 0 {
+			return nil
+		}
+		found = true
+		err := s.decode(bkt.Get(keys[0]), result)
+		if err != nil {
+			return err
+		}
+		//autofill KeyField in struct
+		return s.decodeKey(keys[0], resultVal)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
 	}
-	query.Limit(1)
-	return s.findQuery(source, result, query)
+	return nil
 }
 
 func (s *Store) updateQuery(source BucketSource, dataType interface{}, query *Query, update func(record interface{}) error) error {
@@ -196,9 +311,15 @@ func (s *Store) updateQuery(source BucketSource, dataType interface{}, query *Qu
 		return err
 	}
 
-	storer := s.newStorer(dataType)
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
 	return s.runQuery(source, dataType, reflect.TypeOf(dataType), query, func(keys keyList, tp reflect.Type, bkt *bolt.Bucket) error {
 		for _, k := range keys {
+			if err := ctxErr(query.ctx); err != nil {
+				return err
+			}
 			v := bkt.Get(k)
 
 			val := reflect.New(tp)
@@ -248,9 +369,15 @@ func (s *Store) deleteQuery(source BucketSource, dataType interface{}, query *Qu
 		return err
 	}
 
-	storer := s.newStorer(dataType)
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
 	return s.runQuery(source, dataType, reflect.TypeOf(dataType), query, func(keys keyList, tp reflect.Type, bkt *bolt.Bucket) error {
 		for _, k := range keys {
+			if err := ctxErr(query.ctx); err != nil {
+				return err
+			}
 			v := bkt.Get(k)
 
 			val := reflect.New(tp)
@@ -288,6 +415,7 @@ func (s *Store) countQuery(source BucketSource, dataType interface{}, query *Que
 	fixedQuery := *query
 	fixedQuery.limit = 0
 	fixedQuery.offset = 0
+	fixedQuery.orderBy = nil
 	//check result type
 	count := 0
 	//run query
@@ -325,61 +453,89 @@ func (s *Store) findQuery(source BucketSource, result interface{}, query *Query)
 		tp = tp.Elem()
 	}
 
-	//for autofill KeyField in struct
-	var keyType reflect.Type
-	var keyField string
-
-	for i := 0; i < tp.NumField(); i++ {
-		if strings.Contains(string(tp.Field(i).Tag), BoltholdKeyTag) {
-			keyType = tp.Field(i).Type
-			keyField = tp.Field(i).Name
-			break
-		}
-	}
-
 	val := reflect.New(tp)
 
 	dataType := val.Interface()
 
 	//run query
 	return s.runQuery(source, dataType, tp, query, func(keys keyList, tp reflect.Type, bkt *bolt.Bucket) error {
-		for _, k := range keys {
-			v := bkt.Get(k)
-
-			val := reflect.New(tp)
-			err := s.decode(v, val.Interface())
-			if err != nil {
-				return err
-			}
-			rowValue := val.Elem()
-
-			if keyType != nil {
-				rowKey := rowValue
-				for rowKey.Kind() == reflect.Ptr {
-					rowKey = rowKey.Elem()
-				}
-				err := s.decode(k, rowKey.FieldByName(keyField).Addr().Interface())
-				if err != nil {
-					return err
-				}
-			}
-
-			sliceVal = reflect.Append(sliceVal, rowValue)
+		sliceVal, err := s.appendRecords(query.ctx, sliceVal, keys, tp, bkt)
+		if err != nil {
+			return err
 		}
 		resultVal.Elem().Set(sliceVal.Slice(0, sliceVal.Len()))
 		return nil
 	})
 }
 
+// appendRecords decodes the records of keys from bkt and appends them to sliceVal, until ctx is done
+func (s *Store) appendRecords(ctx context.Context, sliceVal reflect.Value, keys keyList, tp reflect.Type,
+	bkt *bolt.Bucket) (reflect.Value, error) {
+	for _, k := range keys {
+		if err := ctxErr(ctx); err != nil {
+			return sliceVal, err
+		}
+		v := bkt.Get(k)
+
+		val := reflect.New(tp)
+		err := s.decode(v, val.Interface())
+		if err != nil {
+			return sliceVal, err
+		}
+		rowValue := val.Elem()
+
+		//autofill KeyField in struct
+		err = s.decodeKey(k, rowValue)
+		if err != nil {
+			return sliceVal, err
+		}
+
+		sliceVal = reflect.Append(sliceVal, rowValue)
+	}
+	return sliceVal, nil
+}
+
 func (s *Store) runQuery(source BucketSource, dataType interface{}, tp reflect.Type, query *Query, action func(keys keyList, tp reflect.Type, bkt *bolt.Bucket) error) error {
 	//run query
-	storer := s.newStorer(dataType)
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
 	mainBkt := source.Bucket([]byte(storer.Type()))
 	if mainBkt == nil {
 		// if the bucket doesn't exist or is empty then our job is really easy!
 		return nil
 	}
+	rType := recordType(dataType)
+
+	keys, _, err := s.queryKeys(source, storer, mainBkt, rType, query)
+	if err != nil {
+		return err
+	}
+	return action(keys, tp, mainBkt)
+}
+
+// queryKeys returns the keys of the records matching query, and the index value each key was found with
+func (s *Store) queryKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query) (keyList, [][]byte, error) {
+	keys := make(keyList, 0)
+	var values [][]byte
+	err := s.walkKeys(source, storer, mainBkt, rType, query, func(key, value []byte) (bool, error) {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
 
+// walkKeys passes the keys of the records matching query to fn, with the index value each key was found under,
+// in query order, until fn returns false.  Or and OrderBy queries collect the keys before passing them, And
+// queries collect the keys matching their And queries before walking their own index
+func (s *Store) walkKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query, fn func(key, value []byte) (bool, error)) error {
 	isQueryPrimaryKey := false
 	var queryBkt *bolt.Bucket
 	if query.index == "" {
@@ -391,392 +547,837 @@ func (s *Store) runQuery(source BucketSource, dataType interface{}, tp reflect.T
 	if query.index != "" && queryBkt == nil {
 		return fmt.Errorf("index [%s] does not exist", query.index)
 	}
+	if len(query.orderBy) > 0 || len(query.or) > 0 {
+		collect := s.unionKeys
+		if len(query.orderBy) > 0 {
+			collect = s.orderedKeys
+		}
+		keys, _, err := collect(source, storer, mainBkt, rType, query)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := ctxErr(query.ctx); err != nil {
+				return err
+			}
+			if more, err := fn(k, k); err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(query.and) > 0 {
+		keys, err := s.intersectQueries(source, storer, mainBkt, rType, query)
+		if err != nil {
+			return err
+		}
+		if query.within != nil {
+			keys = intersect(*query.within, keys)
+		}
+		walked := *query
+		walked.and = nil
+		walked.within = &keys
+		query = &walked
+	}
+	query, err := s.convertQuery(storer, rType, query)
+	if err != nil {
+		return err
+	}
+	if index, ok := storer.Indexes()[query.index]; ok && index.Desc {
+		query = descQuery(query)
+	}
 
 	c := queryBkt.Cursor()
-	var keys = make(keyList, 0)
+	accept, err := s.keyFilter(source, storer, mainBkt, rType, query)
+	if err != nil {
+		return err
+	}
 
-	switch query.queryType {
-	case QueryRange:
-		if len(query.rangeCriteria) > 2 {
-			return errors.New("range condition error,max condition count is 2")
+	excludedValues := make(map[string]bool, len(query.notIn))
+	for _, value := range query.notIn {
+		encoded, err := s.encodeQueryValue(storer, query.index, value)
+		if err != nil {
+			return fmt.Errorf("query value encode err:%s", err.Error())
 		}
+		excludedValues[string(encoded)] = true
+	}
+	excluded := func(k []byte) bool {
+		return excludedValues[string(k)]
+	}
 
-		var forStart func(c *bolt.Cursor) ([]byte, []byte)
-		var forCondition func(k []byte) bool
-		var forNext func(c *bolt.Cursor) ([]byte, []byte)
+	// emit passes an accepted key to fn, applying the offset and limit of the query
+	leftOffset, count := query.offset, 0
+	emit := func(key, value []byte) (bool, error) {
+		query.stats.add(0, 1, 0)
+		if err := ctxErr(query.ctx); err != nil {
+			return false, err
+		}
+		if accept != nil {
+			ok, err := accept(key)
+			if err != nil || !ok {
+				return err == nil, err
+			}
+		}
+		if leftOffset > 0 {
+			leftOffset--
+			return true, nil
+		}
+		count++
+		more, err := fn(key, value)
+		if err != nil || !more {
+			return false, err
+		}
+		return query.limit == 0 || count < query.limit, nil
+	}
+	// emitFound emits the keys found under the index value k, v is the record or the key list stored under k
+	emitFound := func(k, v []byte) (bool, error) {
+		query.stats.add(1, 0, 0)
+		if err := ctxErr(query.ctx); err != nil {
+			return false, err
+		}
+		// skip the keys up to the position of After
+		var afterKey []byte
+		if query.after != nil {
+			switch query.after.compare(k, query.reverse) {
+			case -1:
+				return true, nil
+			case 0:
+				afterKey = query.after.key
+			}
+		}
 
-		if len(query.rangeCriteria) == 0 {
-			if query.reverse {
-				forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-					return c.Last()
-				}
-				forCondition = func(k []byte) bool {
-					return k != nil
-				}
-			} else {
-				forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-					return c.First()
-				}
-				forCondition = func(k []byte) bool {
-					return k != nil
-				}
+		if isQueryPrimaryKey {
+			if afterKey != nil {
+				return true, nil
+			}
+			return emit(k, k)
+		}
+		var found keyList
+		err := s.decode(v, &found)
+		if err != nil {
+			return false, err
+		}
+		for _, key := range found {
+			if afterKey != nil && bytes.Compare(key, afterKey) <= 0 {
+				continue
 			}
-		} else {
+			more, err := emit(key, k)
+			if err != nil || !more {
+				return false, err
+			}
+		}
+		return true, nil
+	}
 
-			switch query.rangeCriteria[0].op {
-			case OpGe:
-				seekMin, err := s.encode(query.rangeCriteria[0].value)
-				if err != nil {
-					return fmt.Errorf("query value encode err:%s", err.Error())
-				}
+	switch query.queryType {
+	case QueryRange:
+		in, err := s.rangeInterval(storer, query)
+		if err != nil {
+			return err
+		}
+		if in.empty() {
+			return nil
+		}
+		next := c.Next
+		if query.reverse {
+			next = c.Prev
+		}
 
-				if query.reverse {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-						return c.Last()
-					}
-					forCondition = func(k []byte) bool {
-						return bytes.Compare(k, seekMin) >= 0
-					}
+		k, v := in.first(c, query.reverse)
+		if query.after != nil && k != nil && query.after.compare(k, query.reverse) < 0 {
+			// resume at the position of After instead of walking the keys before it
+			k, v = c.Seek(query.after.value)
+			if query.reverse && !bytes.Equal(k, query.after.value) {
+				if k == nil {
+					k, v = c.Last()
 				} else {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-						return c.Seek(seekMin)
-					}
-					forCondition = func(k []byte) bool {
-						return k != nil
-					}
-				}
-
-			case OpGt:
-				seekMin, err := s.encode(query.rangeCriteria[0].value)
-				if err != nil {
-					return fmt.Errorf("query value encode err:%s", err.Error())
+					k, v = c.Prev()
 				}
+			}
+		}
+		for ; in.walkable(k, query.reverse); k, v = next() {
+			if excluded(k) {
+				continue
+			}
+			more, err := emitFound(k, v)
+			if err != nil || !more {
+				return err
+			}
+		}
+	case QueryEqual:
+		seek, err := s.encodeQueryValue(storer, query.index, query.equalCriteria.value)
+		if err != nil {
+			return fmt.Errorf("query value encode err:%s", err.Error())
+		}
 
-				if query.reverse {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-						return c.Last()
-					}
-					forCondition = func(k []byte) bool {
-						return bytes.Compare(k, seekMin) > 0
-					}
-				} else {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-						k, v := c.Seek(seekMin)
-						if bytes.Compare(k, seekMin) == 0 {
-							return c.Next()
-						}
-						return k, v
-					}
-					forCondition = func(k []byte) bool {
-						return k != nil
-					}
-				}
-			case OpLe:
-				value, err := s.encode(query.rangeCriteria[0].value)
-				if err != nil {
-					return fmt.Errorf("query value encode err:%s", err.Error())
-				}
-				if query.reverse {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
+		key, v := c.Seek(seek)
+		//query value not exist
+		if key == nil || v == nil {
+			return nil
+		}
+		if bytes.Compare(key, seek) != 0 || excluded(key) {
+			return nil
+		}
+		_, err = emitFound(key, v)
+		return err
 
-						k, v := c.Seek(value)
-						if bytes.Compare(k, value) > 0 {
-							k, v = c.Prev()
-						}
+	case QueryIn:
+		seeks := make(keyList, 0, len(query.inCriteria))
+		for _, value := range query.inCriteria {
+			seek, err := s.encodeQueryValue(storer, query.index, value)
+			if err != nil {
+				return fmt.Errorf("query value encode err:%s", err.Error())
+			}
+			seeks = append(seeks, seek)
+		}
+		seeks = sortKeys(seeks)
+		if query.reverse {
+			seeks = reverse(seeks)
+		}
 
-						return k, v
+		for i, seek := range seeks {
+			if (i > 0 && bytes.Equal(seek, seeks[i-1])) || excluded(seek) {
+				continue
+			}
+			k, v := c.Seek(seek)
+			if k == nil || !bytes.Equal(k, seek) {
+				continue
+			}
+			more, err := emitFound(k, v)
+			if err != nil || !more {
+				return err
+			}
+		}
+	}
 
-					}
-					forCondition = func(k []byte) bool {
-						return k != nil
-					}
+	return nil
+}
 
-				} else {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-						return c.First()
-					}
-					forCondition = func(k []byte) bool {
-						if k == nil {
-							return false
-						}
-						return bytes.Compare(k, value) <= 0
-					}
-				}
+// keyFilter returns the function accepting the keys of the live records found by the other queries and matching
+// the residual predicates of query, or nil if every key is accepted
+func (s *Store) keyFilter(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query) (func(key []byte) (bool, error), error) {
+	var filters []func(key []byte) (bool, error)
+	if query.within != nil {
+		filters = append(filters, func(key []byte) (bool, error) {
+			return query.within.in(key), nil
+		})
+	}
+	if len(query.excludeKeys) > 0 {
+		excludedKeys, err := s.encodeKeys(storer, rType, query.excludeKeys)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(key []byte) (bool, error) {
+			return !excludedKeys[string(key)], nil
+		})
+	}
+	if live := s.liveFunc(source, storer); live != nil {
+		filters = append(filters, func(key []byte) (bool, error) {
+			return live(key), nil
+		})
+	}
 
-			case OpLt:
-				value, err := s.encode(query.rangeCriteria[0].value)
-				if err != nil {
-					return fmt.Errorf("query value encode err:%s", err.Error())
-				}
-				if query.reverse {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
+	if len(query.where) > 0 || len(query.filters) > 0 {
+		match, err := s.recordMatcher(rType, query)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(key []byte) (bool, error) {
+			query.stats.add(0, 0, 1)
+			record := reflect.New(rType)
+			err := s.decode(mainBkt.Get(key), record.Interface())
+			if err != nil {
+				return false, err
+			}
+			err = s.decodeKey(key, record)
+			if err != nil {
+				return false, err
+			}
+			return match(record), nil
+		})
+	}
 
-						k, v := c.Seek(value)
-						if bytes.Compare(k, value) >= 0 {
-							k, v = c.Prev()
-						}
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	}
+	return func(key []byte) (bool, error) {
+		for _, filter := range filters {
+			ok, err := filter(key)
+			if !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}, nil
+}
 
-						return k, v
+// unionKeys returns the keys of the records matching query or any of its or queries, in the order of the index
+// of query.  The records found by the or queries must also pass the NotIn values, excluded keys and residual
+// predicates of query, the records they find which aren't in the index of query come last, in key order
+func (s *Store) unionKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query) (keyList, [][]byte, error) {
+	all := *query
+	all.limit = 0
+	all.offset = 0
+	all.or = nil
+	keys, values, err := s.queryKeys(source, storer, mainBkt, rType, &all)
+	if err != nil {
+		return nil, nil, err
+	}
 
-					}
-					forCondition = func(k []byte) bool {
-						return k != nil
-					}
+	// the conditions of query checked on the records of the or queries, besides its index range
+	residual := &Query{
+		index:       query.index,
+		notIn:       query.notIn,
+		excludeKeys: query.excludeKeys,
+		where:       query.where,
+		filters:     query.filters,
+		stats:       query.stats,
+		ctx:         query.ctx,
+		queryType:   QueryRange,
+	}
+	converted, err := s.convertQuery(storer, rType, residual)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, ok := storer.Indexes()[query.index]
+	desc := ok && index.Desc
+	if desc {
+		converted = descQuery(converted)
+	}
+	notExcluded, err := s.valueMatcher(storer, converted)
+	if err != nil {
+		return nil, nil, err
+	}
+	accept, err := s.keyFilter(source, storer, mainBkt, rType, converted)
+	if err != nil {
+		return nil, nil, err
+	}
 
-				} else {
-					forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-						return c.First()
-					}
-					forCondition = func(k []byte) bool {
-						if k == nil {
-							return false
-						}
-						return bytes.Compare(k, value) < 0
-					}
+	found := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		found[string(k)] = true
+	}
+	for _, or := range query.or {
+		all := *or
+		all.limit = 0
+		all.offset = 0
+		all.or = nil
+		orKeys, _, err := s.queryKeys(source, storer, mainBkt, rType, &all)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, k := range orKeys {
+			if found[string(k)] {
+				continue
+			}
+			found[string(k)] = true
+			value, err := s.storedIndexValue(storer, mainBkt, rType, converted, k)
+			if err != nil {
+				return nil, nil, err
+			}
+			if value != nil && !notExcluded(value) {
+				continue
+			}
+			if accept != nil {
+				ok, err := accept(k)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !ok {
+					continue
 				}
 			}
+			keys = append(keys, k)
+			values = append(values, value)
+		}
+	}
+
+	// the index is walked backward when the query or the index is descending, not both
+	reverse := query.reverse != desc
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := values[order[i]], values[order[j]]
+		if (a == nil) != (b == nil) {
+			return b == nil
+		}
+		if cmp := bytes.Compare(a, b); cmp != 0 {
+			return (cmp < 0) != reverse
+		}
+		return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	sorted := make(keyList, len(order))
+	for i, o := range order {
+		sorted[i] = keys[o]
+	}
+	keys = sorted
 
-			if len(query.rangeCriteria) == 2 {
-				switch query.rangeCriteria[1].op {
-				case OpGe:
-					seekMin, err := s.encode(query.rangeCriteria[1].value)
-					if err != nil {
-						return fmt.Errorf("query value encode err:%s", err.Error())
-					}
-
-					if query.reverse {
-						if forStart == nil {
-							forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-								return c.Last()
-							}
-						}
-						forCondition = func(k []byte) bool {
-							return bytes.Compare(k, seekMin) >= 0
-						}
-					} else {
-						forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-							return c.Seek(seekMin)
-						}
-						if forCondition == nil {
-							forCondition = func(k []byte) bool {
-								return k != nil
-							}
-						}
-					}
-
-				case OpGt:
-					seekMin, err := s.encode(query.rangeCriteria[1].value)
-					if err != nil {
-						return fmt.Errorf("query value encode err:%s", err.Error())
-					}
-
-					if query.reverse {
-						if forStart == nil {
-							forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-								return c.Last()
-							}
-						}
-						forCondition = func(k []byte) bool {
-							return bytes.Compare(k, seekMin) > 0
-						}
-					} else {
-						forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-							k, v := c.Seek(seekMin)
-							if bytes.Compare(k, seekMin) == 0 {
-								return c.Next()
-							}
-							return k, v
-						}
-						if forCondition == nil {
-							forCondition = func(k []byte) bool {
-								return k != nil
-							}
-						}
-					}
-
-				case OpLe:
-					value, err := s.encode(query.rangeCriteria[1].value)
-					if err != nil {
-						return fmt.Errorf("query value encode err:%s", err.Error())
-					}
-					if query.reverse {
-						forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-
-							k, v := c.Seek(value)
-							if bytes.Compare(k, value) > 0 {
-								k, v = c.Prev()
-							}
-
-							return k, v
-
-						}
-						if forCondition == nil {
-							forCondition = func(k []byte) bool {
-								return k != nil
-							}
-						}
-					} else {
-						if forStart == nil {
-							forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-								return c.First()
-							}
-						}
-						forCondition = func(k []byte) bool {
-							if k == nil {
-								return false
-							}
-
-							return bytes.Compare(k, value) <= 0
-						}
-					}
-
-				case OpLt:
-					value, err := s.encode(query.rangeCriteria[1].value)
-					if err != nil {
-						return fmt.Errorf("query value encode err:%s", err.Error())
-					}
-
-					if query.reverse {
-						forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-
-							k, v := c.Seek(value)
-							if bytes.Compare(k, value) >= 0 {
-								k, v = c.Prev()
-							}
-
-							return k, v
-
-						}
-						if forCondition == nil {
-							forCondition = func(k []byte) bool {
-								return k != nil
-							}
-						}
-					} else {
-						if forStart == nil {
-							forStart = func(c *bolt.Cursor) ([]byte, []byte) {
-								return c.First()
-							}
-						}
-						forCondition = func(k []byte) bool {
-							if k == nil {
-								return false
-							}
-							return bytes.Compare(k, value) < 0
-						}
-					}
+	if query.offset >= len(keys) {
+		return keyList{}, nil, nil
+	}
+	keys = keys[query.offset:]
+	if query.limit > 0 && query.limit < len(keys) {
+		keys = keys[:query.limit]
+	}
+	return keys, keys, nil
+}
 
-				}
+// encodeKeys returns the set of the encoded keys, converted to the type of the key field
+func (s *Store) encodeKeys(storer Storer, rType reflect.Type, keys []interface{}) (map[string]bool, error) {
+	tp := s.indexType(storer, rType, Key)
+	encoded := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if tp != nil {
+			var err error
+			if key, err = convertValue(key, tp); err != nil {
+				return nil, fmt.Errorf("excluded key: %s", err)
 			}
 		}
+		k, err := s.encode(key)
+		if err != nil {
+			return nil, err
+		}
+		encoded[string(k)] = true
+	}
+	return encoded, nil
+}
 
-		if query.reverse {
-			forNext = func(c *bolt.Cursor) ([]byte, []byte) {
-				return c.Prev()
-			}
-		} else {
-			forNext = func(c *bolt.Cursor) ([]byte, []byte) {
-				return c.Next()
-			}
+// andEstimateLimit is the number of keys counted at most to estimate which query of an And finds the fewest
+// keys
+const andEstimateLimit = 1000
+
+// intersectQueries returns the sorted keys found by the index range of query and matching all of its And queries.
+// The query finding the fewest keys is walked first, then the others are walked through their index keeping
+// only the keys found so far, so no record is read unless an And query has residual predicates.  The index
+// range of query is only walked here when it finds the fewest keys, it's walked by the query anyway
+func (s *Store) intersectQueries(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query) (keyList, error) {
+	queries := append([]*Query{indexRange(query)}, query.and...)
+	driver, fewest := 0, andEstimateLimit
+	for i, q := range queries {
+		n, err := s.estimateKeys(source, storer, mainBkt, rType, q, fewest)
+		if err != nil {
+			return nil, err
+		}
+		if n < fewest || i == 0 {
+			driver, fewest = i, n
 		}
+		if fewest == 0 {
+			break
+		}
+	}
 
-		var k, v []byte
-		leftOffset := query.offset
-		keyCount := 0
-		for k, v = forStart(c); forCondition(k); k, v = forNext(c) {
-			skip := false
-			for _, exclude := range query.excludeKey {
-				if bytes.Compare(k, exclude) == 0 {
-					skip = true
-					break
-				}
-			}
-			if skip {
-				continue
-			}
+	keys, err := s.andKeys(source, storer, mainBkt, rType, queries[driver], nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, q := range queries[1:] {
+		if len(keys) == 0 {
+			break
+		}
+		if i+1 == driver {
+			continue
+		}
+		if keys, err = s.andKeys(source, storer, mainBkt, rType, q, &keys); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
 
-			if isQueryPrimaryKey {
-				keyCount++
-				//offset
-				if query.offset > 0 && keyCount <= query.offset {
-					continue
-				}
+// indexRange returns the query walking the index range of query, without its other conditions
+func indexRange(query *Query) *Query {
+	return &Query{
+		index:         query.index,
+		notIn:         query.notIn,
+		stats:         query.stats,
+		ctx:           query.ctx,
+		queryType:     query.queryType,
+		rangeCriteria: query.rangeCriteria,
+		equalCriteria: query.equalCriteria,
+		inCriteria:    query.inCriteria,
+	}
+}
 
-				keys = append(keys, k)
-				//limit
-				if query.limit > 0 && len(keys) >= query.limit {
-					break
-				}
-			} else {
-				var tempKeysThisRound = make(keyList, 0)
-				err := s.decode(v, &tempKeysThisRound)
-				if err != nil {
-					return err
-				}
+// estimateKeys counts up to limit keys in the index range of query, ignoring its other conditions
+func (s *Store) estimateKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query, limit int) (int, error) {
+	n := 0
+	err := s.walkKeys(source, storer, mainBkt, rType, indexRange(query), func(key, value []byte) (bool, error) {
+		n++
+		return n < limit, nil
+	})
+	return n, err
+}
 
-				//offset
-				left := leftOffset - len(tempKeysThisRound)
-				if left >= 0 {
-					leftOffset = left
-					continue
-				}
-				if leftOffset > 0 {
-					tempKeysThisRound = tempKeysThisRound[leftOffset:]
-					leftOffset = 0
-				}
+// andKeys returns the sorted keys of the records matching the And query, among within when it's set.  The keys
+// out of within are skipped before the residual predicates of query decode their record
+func (s *Store) andKeys(source BucketSource, storer Storer, mainBkt *bolt.Bucket, rType reflect.Type,
+	query *Query, within *keyList) (keyList, error) {
+	all := *query
+	all.limit = 0
+	all.offset = 0
+	all.after = nil
+	all.orderBy = nil
+	all.within = within
+	keys, _, err := s.queryKeys(source, storer, mainBkt, rType, &all)
+	if err != nil {
+		return nil, err
+	}
+	keys = sortKeys(keys)
+	if within != nil {
+		// the keys found by the or queries of query aren't checked against within
+		keys = intersect(*within, keys)
+	}
+	return keys, nil
+}
 
-				//limit
-				limitThisRound := query.limit - keyCount
-				keyCount += len(tempKeysThisRound)
-				if query.limit > 0 && len(tempKeysThisRound) > limitThisRound {
-					tempKeysThisRound = tempKeysThisRound[:limitThisRound]
-				}
+// storedIndexValue returns the value the record of key is stored under in the index of query, the key itself for
+// the Key, or nil when the record isn't indexed
+func (s *Store) storedIndexValue(storer Storer, mainBkt *bolt.Bucket, rType reflect.Type, query *Query,
+	key []byte) ([]byte, error) {
+	if query.index == Key {
+		return key, nil
+	}
+	index := storer.Indexes()[query.index]
+	data := mainBkt.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	query.stats.add(0, 0, 1)
+	record := reflect.New(rType)
+	if err := s.decode(data, record.Interface()); err != nil {
+		return nil, err
+	}
+	if err := s.decodeKey(key, record); err != nil {
+		return nil, err
+	}
+	indexKey, zero, err := s.indexKey(query.index, index, record.Elem().Interface())
+	if err != nil || indexKey == nil || (zero && index.OmitZero) {
+		return nil, err
+	}
+	if index.Desc {
+		return descKey(indexKey), nil
+	}
+	return indexKey, nil
+}
 
-				keys = append(keys, tempKeysThisRound...)
-				if query.limit > 0 && keyCount >= query.limit {
-					break
-				}
-			}
+// valueMatcher returns the function telling whether an index value, as stored, is matched by the range, equal or
+// in criteria of query, and not excluded by NotIn.  query is converted, and reversed for a descending index
+func (s *Store) valueMatcher(storer Storer, query *Query) (func(value []byte) bool, error) {
+	encode := func(value interface{}) (string, error) {
+		encoded, err := s.encodeQueryValue(storer, query.index, value)
+		if err != nil {
+			return "", fmt.Errorf("query value encode err:%s", err.Error())
+		}
+		return string(encoded), nil
+	}
+
+	excluded := make(map[string]bool, len(query.notIn))
+	for _, value := range query.notIn {
+		encoded, err := encode(value)
+		if err != nil {
+			return nil, err
 		}
+		excluded[encoded] = true
+	}
+
+	switch query.queryType {
 	case QueryEqual:
-		seek, err := s.encode(query.equalCriteria.value)
+		seek, err := encode(query.equalCriteria.value)
 		if err != nil {
-			return fmt.Errorf("query value encode err:%s", err.Error())
+			return nil, err
+		}
+		return func(value []byte) bool {
+			return string(value) == seek && !excluded[seek]
+		}, nil
+	case QueryIn:
+		values := make(map[string]bool, len(query.inCriteria))
+		for _, value := range query.inCriteria {
+			encoded, err := encode(value)
+			if err != nil {
+				return nil, err
+			}
+			values[encoded] = true
 		}
+		return func(value []byte) bool {
+			return values[string(value)] && !excluded[string(value)]
+		}, nil
+	}
 
-		key, v := c.Seek(seek)
-		//query value not exist
-		if key == nil || v == nil {
+	in, err := s.rangeInterval(storer, query)
+	if err != nil {
+		return nil, err
+	}
+	return func(value []byte) bool {
+		return in.aboveLower(value) && in.belowUpper(value) && !excluded[string(value)]
+	}, nil
+}
+
+// encodeQueryValue encodes a value compared to the index values of indexName, applying the collation and the
+// order of the index
+func (s *Store) encodeQueryValue(storer Storer, indexName string, value interface{}) ([]byte, error) {
+	index, ok := storer.Indexes()[indexName]
+	if ok && index.Collate != "" {
+		value = collate(index.Collate, value)
+	}
+	encoded, err := s.encode(value)
+	if err != nil || !ok || !index.Desc {
+		return encoded, err
+	}
+	return descKey(encoded), nil
+}
+
+// indexType returns the type of the values of indexName, or nil when it isn't known: the type of the key field
+// for the Key, the Type of the index, or the type of the indexed field, pointers included, for the indexes of
+// tagged types.  The indexes of Storer implementations without a Type may hold any value
+func (s *Store) indexType(storer Storer, rType reflect.Type, indexName string) reflect.Type {
+	if indexName == Key {
+		field := s.keyField(rType)
+		if field == nil {
 			return nil
 		}
-		if bytes.Compare(key, seek) != 0 {
-			return nil
+		tp := field.tp
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
 		}
+		return tp
+	}
+	if index, ok := storer.Indexes()[indexName]; ok && index.Type != nil {
+		return index.Type
+	}
+	anon, ok := storer.(*anonStorer)
+	if !ok {
+		return nil
+	}
+	// the indexed values of pointer fields are pointers
+	_, tp, err := compilePath(rType, strings.Split(anon.paths[indexName], "."))
+	if err != nil {
+		return nil
+	}
+	return tp
+}
 
-		if isQueryPrimaryKey {
-			keys = append(keys, key)
-		} else {
-			err = s.decode(v, &keys)
-			if err != nil {
-				return err
+// convertQuery returns query with its values converted to the type of the values of its index, so they're encoded
+// like them, or an error when a value can't be compared to them
+func (s *Store) convertQuery(storer Storer, rType reflect.Type, query *Query) (*Query, error) {
+	tp := s.indexType(storer, rType, query.index)
+	if tp == nil {
+		return query, nil
+	}
+	convert := func(value interface{}) (interface{}, error) {
+		converted, err := convertValue(value, tp)
+		if err != nil {
+			name := query.index
+			if name == Key {
+				name = "Key"
+			}
+			return nil, fmt.Errorf("query value of index [%s]: %s", name, err)
+		}
+		return converted, nil
+	}
+
+	converted := *query
+	var err error
+	converted.rangeCriteria = make([]*Criterion, len(query.rangeCriteria))
+	for i, c := range query.rangeCriteria {
+		converted.rangeCriteria[i] = &Criterion{op: c.op}
+		if converted.rangeCriteria[i].value, err = convert(c.value); err != nil {
+			return nil, err
+		}
+	}
+	if query.equalCriteria != nil {
+		converted.equalCriteria = &Criterion{op: query.equalCriteria.op}
+		if converted.equalCriteria.value, err = convert(query.equalCriteria.value); err != nil {
+			return nil, err
+		}
+	}
+	for _, values := range []*[]interface{}{&converted.inCriteria, &converted.notIn} {
+		list := make([]interface{}, len(*values))
+		for i, value := range *values {
+			if list[i], err = convert(value); err != nil {
+				return nil, err
 			}
 		}
+		*values = list
+	}
+	return &converted, nil
+}
+
+// convertValue converts value to tp when it holds the same kind of value: integers, unsigned integers and floats
+// are converted to any number type they fit in exactly, strings and bools to named types.  Pointers are followed,
+// and a pointer to the converted value is returned for a pointer type.  A nil value is left to the encoder
+func convertValue(value interface{}, tp reflect.Type) (interface{}, error) {
+	v := reflect.ValueOf(value)
+	if !derefValue(&v) || tp.Kind() == reflect.Interface {
+		return value, nil
+	}
+	if tp.Kind() == reflect.Ptr {
+		elem, err := convertValue(v.Interface(), tp.Elem())
+		if err != nil {
+			return nil, err
+		}
+		ptr := reflect.New(tp.Elem())
+		ptr.Elem().Set(reflect.ValueOf(elem))
+		return ptr.Interface(), nil
+	}
+	if v.Type() == tp {
+		return v.Interface(), nil
+	}
 
-		//handle offset
-		if query.offset > 0 {
-			if query.offset < len(keys) {
-				keys = keys[query.offset:]
-			} else {
-				return nil
+	converted := reflect.New(tp).Elem()
+	inexact := fmt.Errorf("%v of type %s doesn't fit in a %s", v.Interface(), v.Type(), tp)
+	switch number, valueNumber := numberKind(tp.Kind()), numberKind(v.Kind()); {
+	case tp == timeType:
+		// only times are compared to times
+	case number == reflect.Int64 && valueNumber != reflect.Invalid:
+		var i int64
+		switch valueNumber {
+		case reflect.Int64:
+			i = v.Int()
+		case reflect.Uint64:
+			if v.Uint() > math.MaxInt64 {
+				return nil, inexact
+			}
+			i = int64(v.Uint())
+		default:
+			f := v.Float()
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return nil, inexact
 			}
+			i = int64(f)
+		}
+		if converted.OverflowInt(i) {
+			return nil, inexact
+		}
+		converted.SetInt(i)
+		return converted.Interface(), nil
+	case number == reflect.Uint64 && valueNumber != reflect.Invalid:
+		var u uint64
+		switch valueNumber {
+		case reflect.Int64:
+			if v.Int() < 0 {
+				return nil, inexact
+			}
+			u = uint64(v.Int())
+		case reflect.Uint64:
+			u = v.Uint()
+		default:
+			f := v.Float()
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return nil, inexact
+			}
+			u = uint64(f)
+		}
+		if converted.OverflowUint(u) {
+			return nil, inexact
 		}
+		converted.SetUint(u)
+		return converted.Interface(), nil
+	case number == reflect.Float64 && valueNumber != reflect.Invalid:
+		f := toFloat(v)
+		if converted.OverflowFloat(f) {
+			return nil, inexact
+		}
+		converted.SetFloat(f)
+		return converted.Interface(), nil
+	case v.Kind() == tp.Kind() && v.Type().ConvertibleTo(tp):
+		return v.Convert(tp).Interface(), nil
+	}
+	return nil, fmt.Errorf("a %s can't be compared to values of type %s", v.Type(), tp)
+}
 
-		//handle limit
-		if query.limit > 0 && query.limit < len(keys) {
-			keys = keys[:query.limit]
+// descQuery returns the query walking the keys of a descending index matching query: the bounds on values
+// become the opposite bounds on keys, and the keys are walked the other way
+func descQuery(query *Query) *Query {
+	desc := *query
+	desc.reverse = !query.reverse
+	desc.rangeCriteria = make([]*Criterion, len(query.rangeCriteria))
+	for i, c := range query.rangeCriteria {
+		op := c.op
+		switch c.op {
+		case OpGt:
+			op = OpLt
+		case OpGe:
+			op = OpLe
+		case OpLt:
+			op = OpGt
+		case OpLe:
+			op = OpGe
 		}
+		desc.rangeCriteria[i] = &Criterion{op: op, value: c.value}
+	}
+	return &desc
+}
 
+// findKeysQuery decodes into result, a pointer to a slice, the keys of the records matching query, or the distinct
+// index values they were found with if indexValues is set
+func (s *Store) findKeysQuery(source BucketSource, dataType, result interface{}, query *Query,
+	indexValues bool) error {
+	err := checkQuery(&query)
+	if err != nil {
+		return err
+	}
+	if indexValues && (len(query.or) > 0 || len(query.orderBy) > 0) {
+		return errors.New("index values of Or and OrderBy queries are not supported")
 	}
 
-	return action(keys, tp, mainBkt)
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	sliceVal := resultVal.Elem().Slice(0, 0)
+	elType := sliceVal.Type().Elem()
+
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
+	mainBkt := source.Bucket([]byte(storer.Type()))
+	if mainBkt == nil {
+		resultVal.Elem().Set(sliceVal)
+		return nil
+	}
+	rType := recordType(dataType)
+
+	keys, values, err := s.queryKeys(source, storer, mainBkt, rType, query)
+	if err != nil {
+		return err
+	}
+
+	encoded := [][]byte(keys)
+	if indexValues {
+		encoded = nil
+		index := storer.Indexes()[query.index]
+		for i, v := range values {
+			if i > 0 && bytes.Equal(v, values[i-1]) {
+				continue
+			}
+			if index.Desc {
+				v, err = descValue(v)
+				if err != nil {
+					return err
+				}
+			}
+			encoded = append(encoded, v)
+		}
+	}
+
+	for _, v := range encoded {
+		val := reflect.New(elType)
+		err = s.decode(v, val.Interface())
+		if err != nil {
+			return err
+		}
+		sliceVal = reflect.Append(sliceVal, val.Elem())
+	}
+	resultVal.Elem().Set(sliceVal)
+	return nil
 }
@@ -0,0 +1,654 @@
+package meson_bolt_localdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type coveredRecord struct {
+	Hash string `mesondb:"key"`
+	Time int64  `mesondb:"index"`
+	Old  int64  `mesondb:"index,desc,path=Time"`
+	Name string `mesondb:"index,collate=nocase"`
+}
+
+func coveredStore(t *testing.T) *Store {
+	store := openTestStore(t, nil)
+	records := []coveredRecord{
+		{Hash: "a", Time: 3, Name: "X"},
+		{Hash: "b", Time: 1, Name: "y"},
+		{Hash: "c", Time: 3, Name: "x"},
+		{Hash: "d", Time: 7, Name: "Y"},
+		{Hash: "e", Time: -2, Name: "z"},
+	}
+	for _, r := range records {
+		if err := store.Insert(r.Hash, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func Test_findKeys(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query *Query
+		keys  []string
+	}{
+		{nil, []string{"a", "b", "c", "d", "e"}},
+		{NewQuery("Time").Range(Condition(OpLt, int64(3))), []string{"e", "b"}},
+		{NewQuery("Time").Range(Condition(OpGe, int64(3))).Desc(), []string{"d", "a", "c"}},
+		{NewQuery("Old").Range(Condition(OpGe, int64(3))).Desc(), []string{"d", "a", "c"}},
+		{NewQuery("Old").Range().Desc().Limit(2), []string{"d", "a"}},
+		{NewQuery("Name").Equal("Y"), []string{"b", "d"}},
+		{NewQuery("Name").Equal("w"), []string{}},
+		{NewQuery(Key).Range(Condition(OpGt, "c")), []string{"d", "e"}},
+	}
+	for i, test := range tests {
+		keys := []string{"stale"}
+		if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+	}
+}
+
+func Test_findIndexValues(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query  *Query
+		values []int64
+	}{
+		{NewQuery("Time"), []int64{-2, 1, 3, 7}},
+		{NewQuery("Time").Range().Desc(), []int64{7, 3, 1, -2}},
+		{NewQuery("Old").Range().Desc(), []int64{7, 3, 1, -2}},
+		{NewQuery("Old").Range(Condition(OpLe, int64(3))), []int64{-2, 1, 3}},
+		{NewQuery("Time").Range().Limit(3), []int64{-2, 1, 3}},
+		{NewQuery("Time").Equal(int64(3)), []int64{3}},
+	}
+	for i, test := range tests {
+		var values []int64
+		if err := store.FindIndexValues(coveredRecord{}, &values, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Fatalf("query %d: expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	var names []string
+	if err := store.FindIndexValues(coveredRecord{}, &names, NewQuery("Name")); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("expected the 3 collated names, got %v", names)
+	}
+}
+
+func Test_andQuery(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query *Query
+		keys  []string
+	}{
+		{NewQuery("Time").Range(Condition(OpGe, int64(1))).And(NewQuery("Name").Equal("y")), []string{"b", "d"}},
+		{NewQuery("Time").Range().Desc().And(NewQuery("Name").Equal("y")), []string{"d", "b"}},
+		{NewQuery("Time").Range().Desc().Limit(1).And(NewQuery("Name").Equal("y")), []string{"d"}},
+		{NewQuery("Time").Range().Offset(1).And(NewQuery("Name").Equal("x")), []string{"c"}},
+		{NewQuery("Name").Equal("x").And(NewQuery("Time").Equal(int64(3)), NewQuery(Key).Range(Condition(OpGt, "a"))),
+			[]string{"c"}},
+		{NewQuery("Old").Range().And(NewQuery("Name").Equal("w")), []string{}},
+		{NewQuery(Key).Range().And(NewQuery("Time").Range(Condition(OpLt, int64(3))).Limit(1)), []string{"b", "e"}},
+	}
+	for i, test := range tests {
+		keys := []string{}
+		if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+
+		count, err := store.Count(&coveredRecord{}, test.query)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if test.query.limit == 0 && test.query.offset == 0 && count != len(test.keys) {
+			t.Fatalf("query %d: expected a count of %d, got %d", i, len(test.keys), count)
+		}
+	}
+
+	if err := store.FindKeys(&coveredRecord{}, &[]string{}, NewQuery("Time").And(NewQuery("Missing"))); err == nil {
+		t.Fatal("expected an error for a missing index")
+	}
+}
+
+func Test_andIntersection(t *testing.T) {
+	store := openTestStore(t, nil)
+	// 5 rare records among 2000, with the times 7, 107, 207, 307 and 407
+	err := store.Bolt().Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 2000; i++ {
+			r := coveredRecord{Hash: fmt.Sprintf("%04d", i), Time: int64(i % 500), Name: "common"}
+			if i%400 == 7 {
+				r.Name = "rare"
+			}
+			if err := store.TxInsert(tx, r.Hash, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rare := func() *Query { return NewQuery("Name").Equal("rare") }
+	tests := []struct {
+		query *Query
+		keys  []string
+	}{
+		{NewQuery("Time").Range().Desc().And(rare()), []string{"0407", "0807", "1207", "1607", "0007"}},
+		{NewQuery("Time").Range().Desc().Offset(1).Limit(2).And(rare()), []string{"0807", "1207"}},
+		{NewQuery("Old").Range(Condition(OpLt, int64(400))).And(rare()), []string{"0007", "1607", "1207", "0807"}},
+		{NewQuery(Key).Range().Desc().And(rare()), []string{"1607", "1207", "0807", "0407", "0007"}},
+		{NewQuery("Time").Range().NotIn(int64(207)).ExcludeKeys("0007").And(rare()), []string{"1607", "0807", "0407"}},
+		{NewQuery("Time").Range().And(rare().Where("Time", OpGt, int64(300))), []string{"0807", "0407"}},
+		{NewQuery("Time").Range(Condition(OpLt, int64(200))).And(rare().Or(NewQuery(Key).Equal("0001"))),
+			[]string{"0001", "0007", "1607"}},
+	}
+	for i, test := range tests {
+		keys := []string{}
+		if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+	}
+
+	// the keys are intersected through the indexes, without reading the records
+	plan, err := store.Explain(&coveredRecord{}, NewQuery("Time").Range().And(rare()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Rows != 5 || plan.RecordsDecoded != 0 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	var pages []string
+	token := ""
+	for {
+		var records []coveredRecord
+		token, err = store.FindPage(&records, NewQuery("Old").Range().Desc().Limit(2).And(rare()).After(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			pages = append(pages, r.Hash)
+		}
+		if token == "" {
+			break
+		}
+	}
+	if expected := []string{"0407", "0807", "1207", "1607", "0007"}; !reflect.DeepEqual(pages, expected) {
+		t.Fatalf("expected pages of %v, got %v", expected, pages)
+	}
+}
+
+func Test_inQuery(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query *Query
+		keys  []string
+	}{
+		{NewQuery("Time").In(int64(7), int64(-2), int64(5), int64(7)), []string{"e", "d"}},
+		{NewQuery("Time").In(int64(7), int64(-2), int64(3)).Desc(), []string{"d", "a", "c", "e"}},
+		{NewQuery("Old").In(int64(7), int64(-2), int64(3)), []string{"e", "a", "c", "d"}},
+		{NewQuery("Old").In(int64(7), int64(-2), int64(3)).Desc(), []string{"d", "a", "c", "e"}},
+		{NewQuery("Time").In(int64(7), int64(-2), int64(3)).Offset(1).Limit(2), []string{"a", "c"}},
+		{NewQuery("Time").In(int64(1), int64(3)).NotIn(int64(3)), []string{"b"}},
+		{NewQuery("Name").In("X", "Z"), []string{"a", "c", "e"}},
+		{NewQuery(Key).In("d", "b", "q"), []string{"b", "d"}},
+		{NewQuery("Time").In(), []string{}},
+		{NewQuery("Time").NotIn(int64(3), int64(7)), []string{"e", "b"}},
+		{NewQuery("Old").Range().NotIn(int64(3), int64(7)).Desc(), []string{"b", "e"}},
+		{NewQuery("Name").Equal("x").NotIn("X"), []string{}},
+		{NewQuery(Key).NotIn("a", "c"), []string{"b", "d", "e"}},
+		{NewQuery("Time").In(int64(1), int64(3)).And(NewQuery("Name").Equal("x")), []string{"a", "c"}},
+	}
+	for i, test := range tests {
+		keys := []string{}
+		if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+	}
+
+	var values []int64
+	if err := store.FindIndexValues(&coveredRecord{}, &values, NewQuery("Old").In(int64(1), int64(3))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []int64{1, 3}) {
+		t.Fatalf("expected the values [1 3], got %v", values)
+	}
+}
+
+func Test_excludeQuery(t *testing.T) {
+	// a codec prefixing the default encoding, which DefaultEncode doesn't match
+	options := &Options{
+		Encoder: func(value interface{}) ([]byte, error) {
+			b, err := DefaultEncode(value)
+			return append([]byte{'x'}, b...), err
+		},
+		Decoder: func(data []byte, value interface{}) error {
+			return DefaultDecode(data[1:], value)
+		},
+	}
+	for _, store := range []*Store{coveredStore(t), openTestStore(t, options)} {
+		for _, r := range []coveredRecord{{Hash: "a", Time: 3, Name: "X"}, {Hash: "b", Time: 1, Name: "y"},
+			{Hash: "c", Time: 3, Name: "x"}, {Hash: "d", Time: 7, Name: "Y"}, {Hash: "e", Time: -2, Name: "z"}} {
+			if err := store.Upsert(r.Hash, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			query *Query
+			keys  []string
+		}{
+			{NewQuery("Time").Range().ExcludeKeys("a", "e"), []string{"b", "c", "d"}},
+			{NewQuery("Time").Range().ExcludeValues(3, int64(7)), []string{"e", "b"}},
+			{NewQuery("Time").Range().Exclude(int64(3)).ExcludeKeys("b"), []string{"e", "d"}},
+			{NewQuery("Old").Range().Desc().ExcludeValues(7), []string{"a", "c", "b", "e"}},
+			{NewQuery("Name").Equal("x").ExcludeValues("X"), []string{}},
+			{NewQuery("Name").Range().ExcludeValues("y").ExcludeKeys("a"), []string{"c", "e"}},
+			{NewQuery(Key).Range().Exclude("b").ExcludeKeys("c").Desc(), []string{"e", "d", "a"}},
+			{NewQuery("Time").Equal(3).ExcludeKeys("c").Or(NewQuery(Key).Equal("c")), []string{"a"}},
+			{NewQuery("Time").Range().OrderBy("Name").ExcludeKeys("a", "b").Limit(2), []string{"d", "c"}},
+		}
+		for i, test := range tests {
+			keys := []string{"stale"}
+			if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+				t.Fatalf("query %d: %s", i, err)
+			}
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+			}
+		}
+
+		if err := store.FindKeys(&coveredRecord{}, &[]string{}, NewQuery(Key).ExcludeKeys(1)); err == nil {
+			t.Fatal("expected an error for a key of the wrong type")
+		}
+	}
+}
+
+func Test_orQuery(t *testing.T) {
+	store := coveredStore(t)
+
+	tests := []struct {
+		query *Query
+		keys  []string
+	}{
+		{NewQuery("Time").Equal(int64(7)).Or(NewQuery("Name").Equal("x")), []string{"a", "c", "d"}},
+		{NewQuery("Time").Equal(int64(7)).Or(NewQuery("Name").Equal("y")), []string{"b", "d"}},
+		{NewQuery("Time").Equal(int64(7)).Or(NewQuery("Name").Equal("x")).Desc(), []string{"d", "a", "c"}},
+		{NewQuery("Time").Equal(int64(7)).Or(NewQuery("Name").Equal("x"), NewQuery(Key).Equal("e")).Offset(1).Limit(2),
+			[]string{"a", "c"}},
+		{NewQuery("Old").Equal(int64(7)).Or(NewQuery("Name").Equal("x"), NewQuery(Key).Equal("e")).Desc(),
+			[]string{"d", "a", "c", "e"}},
+		{NewQuery("Time").Range(Condition(OpGe, int64(3))).Exclude(int64(7)).Or(NewQuery("Name").In("z").Limit(1)),
+			[]string{"e", "a", "c"}},
+		{NewQuery("Time").Equal(int64(3)).And(NewQuery(Key).Equal("a")).Or(NewQuery("Time").Equal(int64(1))),
+			[]string{"b", "a"}},
+		// the NotIn values, excluded keys and predicates of the query apply to the records of the or queries
+		{NewQuery("Time").Equal(int64(7)).ExcludeKeys("a").Or(NewQuery("Name").Equal("x")), []string{"c", "d"}},
+		{NewQuery("Time").Range(Condition(OpGe, int64(7))).Exclude(int64(3)).Or(NewQuery("Name").Equal("x")),
+			[]string{"d"}},
+		{NewQuery(Key).Range().Where("Time", OpGt, int64(0)).Or(NewQuery("Name").Equal("z")),
+			[]string{"a", "b", "c", "d"}},
+		{NewQuery(Key).Range(Condition(OpLt, "b")).Desc().Or(NewQuery("Name").Equal("y")), []string{"d", "b", "a"}},
+	}
+	for i, test := range tests {
+		keys := []string{}
+		if err := store.FindKeys(&coveredRecord{}, &keys, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("query %d: expected keys %v, got %v", i, test.keys, keys)
+		}
+
+		var records []coveredRecord
+		if err := store.Find(&records, test.query); err != nil || len(records) != len(test.keys) {
+			t.Fatalf("query %d: expected %d records, got %d (%v)", i, len(test.keys), len(records), err)
+		}
+	}
+
+	count, err := store.Count(&coveredRecord{}, NewQuery("Time").Equal(int64(7)).Or(NewQuery("Name").Equal("y")))
+	if err != nil || count != 2 {
+		t.Fatalf("expected a count of 2, got %d (%v)", count, err)
+	}
+}
+
+type filteredOwner struct {
+	Name string
+}
+
+type filteredRecord struct {
+	ID       int    `mesondb:"key"`
+	Group    string `mesondb:"index"`
+	FileSize int64
+	Rate     float32
+	Owner    *filteredOwner
+	Created  time.Time
+	Tags     []string
+}
+
+func Test_whereQuery(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		r := filteredRecord{
+			Group:    []string{"a", "b"}[i%2],
+			FileSize: int64(i * 50),
+			Rate:     float32(i) / 2,
+			Created:  base.Add(time.Duration(i) * time.Hour),
+		}
+		if i%3 == 0 {
+			r.Owner = &filteredOwner{Name: "bob"}
+			r.Tags = []string{"x"}
+		}
+		if err := store.Insert(i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query *Query
+		ids   []int
+	}{
+		{NewQuery(Key).Where("FileSize", OpGt, 300), []int{7, 8, 9}},
+		{(&Query{}).Where("FileSize", OpGt, 300), []int{7, 8, 9}},
+		{NewQuery("Group").Equal("a").Where("FileSize", OpGe, uint8(200)), []int{4, 6, 8}},
+		{NewQuery("Group").Range().Where("FileSize", OpGe, 100).Limit(2).Offset(1), []int{4, 6}},
+		{NewQuery(Key).Range().Desc().Where("FileSize", OpLt, 100.5).Limit(2), []int{2, 1}},
+		{NewQuery(Key).Where("Rate", OpEq, 1.5), []int{3}},
+		{NewQuery(Key).Where("Rate", OpNe, 0).Where("Rate", OpLe, 1), []int{1, 2}},
+		{NewQuery(Key).Where("Owner.Name", OpEq, "bob"), []int{0, 3, 6, 9}},
+		{NewQuery(Key).Where("Owner", OpEq, nil), []int{1, 2, 4, 5, 7, 8}},
+		{NewQuery(Key).Where("Owner.Name", OpNe, "bob"), []int{1, 2, 4, 5, 7, 8}},
+		{NewQuery(Key).Where("Created", OpGe, base.Add(8*time.Hour)), []int{8, 9}},
+		{NewQuery(Key).Where("Tags", OpEq, []string{"x"}), []int{0, 3, 6, 9}},
+		{NewQuery("Group").Equal("b").Filter(func(record interface{}) bool {
+			r := record.(*filteredRecord)
+			return r.ID > 4 && r.Owner == nil
+		}), []int{5, 7}},
+		{NewQuery("Group").In("a").Where("ID", OpGt, 5), []int{6, 8}},
+	}
+	for i, test := range tests {
+		var records []filteredRecord
+		if err := store.Find(&records, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		ids := make([]int, len(records))
+		for j := range records {
+			ids[j] = records[j].ID
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Fatalf("query %d: expected records %v, got %v", i, test.ids, ids)
+		}
+	}
+
+	count, err := store.Count(&filteredRecord{}, NewQuery("Group").Equal("a").Where("FileSize", OpGe, 200))
+	if err != nil || count != 3 {
+		t.Fatalf("expected a count of 3, got %d (%v)", count, err)
+	}
+
+	err = store.DeleteMatching(&filteredRecord{}, NewQuery(Key).Where("FileSize", OpGt, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, err := store.Count(&filteredRecord{}, nil); err != nil || count != 7 {
+		t.Fatalf("expected 7 records left, got %d (%v)", count, err)
+	}
+
+	for _, q := range []*Query{
+		NewQuery(Key).Where("Missing", OpEq, 1),
+		NewQuery(Key).Where("Tags", OpGt, 1),
+		NewQuery(Key).Where("", OpEq, 1),
+		NewQuery(Key).Where("Group", OpEq, 97),
+		NewQuery(Key).Where("FileSize", OpNe, "100"),
+		NewQuery(Key).Where("Created", OpGt, 1),
+		NewQuery(Key).Where("Tags", OpEq, [1]string{"x"}),
+		NewQuery(Key).Where("Owner", OpEq, "bob"),
+		NewQuery(Key).Range(Condition(OpNe, 1)),
+	} {
+		if err := store.Find(&[]filteredRecord{}, q); err == nil {
+			t.Fatalf("expected an error for %+v", q)
+		}
+	}
+}
+
+type orderedRecord struct {
+	ID       int    `mesondb:"key"`
+	Group    string `mesondb:"index"`
+	FileSize int64  `mesondb:"index"`
+	Age      int64  `mesondb:"index,desc"`
+	Name     string
+	Owner    *filteredOwner
+}
+
+func Test_orderBy(t *testing.T) {
+	// the store with its own encoder sorts in memory instead of walking the FileSize and Age indexes
+	for _, options := range []*Options{nil, {Encoder: DefaultEncode}} {
+		store := openTestStore(t, options)
+		for i := 0; i < 10; i++ {
+			r := orderedRecord{
+				Group:    []string{"a", "b"}[i%2],
+				FileSize: int64(i%4) * 100,
+				Age:      int64(i%4) * 100,
+				Name:     fmt.Sprintf("n%d", 9-i),
+			}
+			if i%3 == 0 {
+				r.Owner = &filteredOwner{Name: []string{"d", "c", "b", "a"}[i/3]}
+			}
+			if err := store.Insert(i, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			query *Query
+			ids   []int
+		}{
+			{NewQuery(Key).OrderBy("FileSize", Desc).ThenBy(Key), []int{3, 7, 2, 6, 1, 5, 9, 0, 4, 8}},
+			{NewQuery(Key).OrderBy("FileSize", Desc).ThenBy(Key, Desc).Offset(1).Limit(3), []int{3, 6, 2}},
+			{NewQuery("Group").Equal("a").OrderBy("FileSize", Desc).ThenBy(Key), []int{2, 6, 0, 4, 8}},
+			{NewQuery("Group").Equal("c").OrderBy("FileSize"), []int{}},
+			{NewQuery(Key).Desc().OrderBy("Age").Limit(4), []int{0, 4, 8, 1}},
+			{NewQuery(Key).OrderBy("Age", Desc).ThenBy("Name").Limit(2), []int{7, 3}},
+			{NewQuery(Key).OrderBy("Name").Limit(3), []int{9, 8, 7}},
+			{NewQuery(Key).Where("FileSize", OpGe, 200).OrderBy("Name", Desc), []int{2, 3, 6, 7}},
+			{NewQuery(Key).OrderBy("Owner.Name").ThenBy(Key, Desc), []int{9, 6, 3, 0, 8, 7, 5, 4, 2, 1}},
+			{NewQuery(Key).OrderBy("Owner.Name", Desc).Limit(6), []int{0, 3, 6, 9, 1, 2}},
+		}
+		for i, test := range tests {
+			records := []orderedRecord{}
+			if err := store.Find(&records, test.query); err != nil {
+				t.Fatalf("query %d: %s", i, err)
+			}
+			ids := make([]int, len(records))
+			for j := range records {
+				ids[j] = records[j].ID
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Fatalf("query %d: expected records %v, got %v", i, test.ids, ids)
+			}
+		}
+
+		count, err := store.Count(&orderedRecord{}, NewQuery("Group").Equal("b").OrderBy("Name").Limit(1))
+		if err != nil || count != 5 {
+			t.Fatalf("expected a count of 5, got %d (%v)", count, err)
+		}
+
+		for _, q := range []*Query{
+			NewQuery(Key).OrderBy("Missing"),
+			NewQuery(Key).OrderBy("Owner"),
+			NewQuery(Key).OrderBy("Name", Asc, Desc),
+		} {
+			if err := store.Find(&[]orderedRecord{}, q); err == nil {
+				t.Fatalf("expected an error for %+v", q)
+			}
+		}
+		if err := store.FindIndexValues(&orderedRecord{}, &[]int64{}, NewQuery("FileSize").OrderBy("Name")); err == nil {
+			t.Fatal("expected an error for the index values of an ordered query")
+		}
+	}
+}
+
+type convertedName string
+
+type convertedRecord struct {
+	ID    uint16        `mesondb:"key"`
+	Rate  float64       `mesondb:"index"`
+	Size  uint64        `mesondb:"index"`
+	Level int8          `mesondb:"index,desc"`
+	Name  convertedName `mesondb:"index"`
+	Seen  *time.Time    `mesondb:"index"`
+}
+
+func Test_convertQuery(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		seen := base.Add(time.Duration(i) * time.Hour)
+		r := convertedRecord{
+			ID:    uint16(i),
+			Rate:  float64(i) / 2,
+			Size:  uint64(i * 100),
+			Level: int8(i - 2),
+			Name:  convertedName([]string{"a", "b"}[i%2]),
+			Seen:  &seen,
+		}
+		if err := store.Insert(r.ID, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query *Query
+		ids   []uint16
+	}{
+		{NewQuery("Rate").Range(Condition(OpGe, 1)), []uint16{2, 3, 4}},
+		{NewQuery("Rate").Equal(int64(1)), []uint16{2}},
+		{NewQuery("Rate").Range(Condition(OpLt, float32(1.5))), []uint16{0, 1, 2}},
+		{NewQuery("Size").Range(Condition(OpGt, 100), Condition(OpLe, 300.0)), []uint16{2, 3}},
+		{NewQuery("Size").In(0, uint8(200)).NotIn(int64(200)), []uint16{0}},
+		{NewQuery("Level").Range(Condition(OpGe, 0)).Desc(), []uint16{4, 3, 2}},
+		{NewQuery("Level").Equal(int64(-2)), []uint16{0}},
+		{NewQuery("Name").Equal("b"), []uint16{1, 3}},
+		{NewQuery("Seen").Equal(base.Add(3 * time.Hour)), []uint16{3}},
+		{NewQuery(Key).Range(Condition(OpGe, 3)), []uint16{3, 4}},
+		{NewQuery(Key).Range(Condition(OpGe, 0)).And(NewQuery("Size").Equal(400)), []uint16{4}},
+		{NewQuery("Rate").Range(Condition(OpGe, 0.5)).Or(NewQuery("Level").Equal(-2)).Limit(3), []uint16{0, 1, 2}},
+	}
+	for i, test := range tests {
+		var records []convertedRecord
+		if err := store.Find(&records, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		ids := make([]uint16, len(records))
+		for j := range records {
+			ids[j] = records[j].ID
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Fatalf("query %d: expected records %v, got %v", i, test.ids, ids)
+		}
+	}
+
+	for i, q := range []*Query{
+		NewQuery("Rate").Equal("1"),
+		NewQuery("Size").Range(Condition(OpGe, -1)),
+		NewQuery("Size").Equal(1.5),
+		NewQuery("Level").Equal(300),
+		NewQuery("Name").Equal(1),
+		NewQuery("Seen").Range(Condition(OpGe, 3)),
+		NewQuery(Key).Equal(70000),
+		NewQuery(Key).Range().And(NewQuery("Size").In(1, "2")),
+	} {
+		if err := store.Find(&[]convertedRecord{}, q); err == nil {
+			t.Fatalf("query %d: expected an error", i)
+		}
+	}
+}
+
+// typedRecord is a Storer declaring the type of the values of its Size index, not of its Untyped index
+type typedRecord struct {
+	ID   int
+	Size uint64
+}
+
+func (typedRecord) Type() string { return "typedRecord" }
+
+func (typedRecord) Indexes() map[string]Index {
+	size := func(record interface{}) interface{} {
+		if r, ok := record.(*typedRecord); ok {
+			return r.Size
+		}
+		return record.(typedRecord).Size
+	}
+	return map[string]Index{
+		"Size":    {ValueFunc: size, Type: reflect.TypeOf(uint64(0))},
+		"Untyped": {ValueFunc: size},
+	}
+}
+
+func Test_convertStorerQuery(t *testing.T) {
+	store := openTestStore(t, nil)
+	for i := 0; i < 3; i++ {
+		if err := store.Insert(i, typedRecord{ID: i, Size: uint64(i * 100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query *Query
+		ids   []int
+	}{
+		{NewQuery("Size").Equal(100), []int{1}},
+		{NewQuery("Size").Range(Condition(OpGe, 150.0)), []int{2}},
+		{NewQuery("Untyped").Equal(uint64(200)), []int{2}},
+		// the values of an index without a Type aren't converted
+		{NewQuery("Untyped").Equal(200), []int{}},
+	}
+	for i, test := range tests {
+		var records []typedRecord
+		if err := store.Find(&records, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		ids := []int{}
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Fatalf("query %d: expected records %v, got %v", i, test.ids, ids)
+		}
+	}
+
+	if err := store.Find(&[]typedRecord{}, NewQuery("Size").Equal(-1)); err == nil {
+		t.Fatal("expected an error for a negative size")
+	}
+}
@@ -0,0 +1,189 @@
+package meson_bolt_localdb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// BoltholdBucketTag is the struct tag used to choose the bucket a type is stored in instead of its type name.
+// It can be set on any field, usually a blank one:
+//
+//	_ struct{} `boltholdBucket:"files"`
+const BoltholdBucketTag = "boltholdBucket"
+
+// ErrTypeConflict is returned when two different types would be stored in the same bucket, or a type is
+// registered under two different names
+var ErrTypeConflict = errors.New("type conflicts with a registered type")
+
+// BucketNamer can be implemented to choose the bucket a type is stored in instead of its type name
+type BucketNamer interface {
+	BoltholdBucket() string
+}
+
+// typeRegistry keeps track of the bucket name used for every type seen by the store, so two types never share
+// a bucket and its indexes
+type typeRegistry struct {
+	sync.RWMutex
+	qualified bool
+	names     map[reflect.Type]string
+	types     map[string]reflect.Type
+}
+
+func newTypeRegistry(qualified bool) *typeRegistry {
+	return &typeRegistry{
+		qualified: qualified,
+		names:     make(map[reflect.Type]string),
+		types:     make(map[string]reflect.Type),
+	}
+}
+
+// Register sets the name of the bucket the type of example is stored in.  If name is empty, the package
+// qualified type name is used, for instance "github.com/me/app/records.Record".
+// Types must be registered before they are first used by the store, and a name can only be used by one type,
+// otherwise an error matching ErrTypeConflict is returned
+func (s *Store) Register(example interface{}, name string) error {
+	tp := reflect.TypeOf(example)
+	if tp == nil {
+		return errors.New("can't register a nil type")
+	}
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	if name == "" {
+		if tp.Name() == "" {
+			return errors.New("can't register an unnamed type without a name")
+		}
+		name = qualifiedTypeName(tp)
+	}
+
+	// everything is checked before the name is recorded, so a failed registration can be retried
+	if err := s.types.check(tp, name); err != nil {
+		return err
+	}
+	var storer *anonStorer
+	if _, ok := example.(Storer); !ok {
+		var err error
+		storer, err = reflectStorer(example, tp, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.types.add(tp, name); err != nil {
+		return err
+	}
+	// lets the sweeper find the expired records of the type before it's used
+	if storer != nil {
+		s.cacheStorer(storer)
+		return nil
+	}
+	_, err := s.newStorer(example)
+	return err
+}
+
+// check returns an error if name isn't a valid bucket name, or can't be the name of tp
+func (r *typeRegistry) check(tp reflect.Type, name string) error {
+	if err := validTypeName(name); err != nil {
+		return err
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	return r.conflict(tp, name)
+}
+
+func (r *typeRegistry) conflict(tp reflect.Type, name string) error {
+	if existing, ok := r.names[tp]; ok && existing != name {
+		return fmt.Errorf("%w: %s is already registered as %q", ErrTypeConflict, tp, existing)
+	}
+	if existing, ok := r.types[name]; ok && existing != tp {
+		return fmt.Errorf("%w: %q is already used by %s", ErrTypeConflict, name, existing)
+	}
+	return nil
+}
+
+// add records name as the bucket name of tp
+func (r *typeRegistry) add(tp reflect.Type, name string) error {
+	if err := validTypeName(name); err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.conflict(tp, name); err != nil {
+		return err
+	}
+	r.names[tp] = name
+	r.types[name] = tp
+	return nil
+}
+
+// registered returns the name explicitly or implicitly registered for tp
+func (r *typeRegistry) registered(tp reflect.Type) (string, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	name, ok := r.names[tp]
+	return name, ok
+}
+
+// typeName returns the bucket name of tp, the first time a type is seen its name is resolved from a
+// BoltholdBucket method, a boltholdBucket tag or the type name, and then recorded like an explicit registration
+func (r *typeRegistry) typeName(tp reflect.Type) (string, error) {
+	if name, ok := r.registered(tp); ok {
+		return name, nil
+	}
+
+	name := tp.Name()
+	if r.qualified {
+		name = qualifiedTypeName(tp)
+	}
+
+	if namer, ok := reflect.New(tp).Interface().(BucketNamer); ok {
+		name = namer.BoltholdBucket()
+	} else if tp.Kind() == reflect.Struct {
+		for i := 0; i < tp.NumField(); i++ {
+			if bucket, ok := tp.Field(i).Tag.Lookup(BoltholdBucketTag); ok {
+				name = bucket
+				break
+			}
+		}
+	}
+
+	if err := r.add(tp, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func qualifiedTypeName(tp reflect.Type) string {
+	if tp.PkgPath() == "" {
+		return tp.Name()
+	}
+	return tp.PkgPath() + "." + tp.Name()
+}
+
+// validTypeName checks that a bucket name can't be confused with another one once used in an index bucket name
+func validTypeName(name string) error {
+	if name == "" {
+		return errors.New("type name is empty")
+	}
+	if strings.Contains(name, ":") {
+		return fmt.Errorf("type name %q can't contain ':'", name)
+	}
+	return nil
+}
+
+// registeredStorer overrides the type name of a Storer registered with another name
+type registeredStorer struct {
+	Storer
+	name string
+}
+
+func (r *registeredStorer) Type() string {
+	return r.name
+}
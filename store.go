@@ -1,10 +1,16 @@
 package meson_bolt_localdb
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/daqnext/meson-bolt-localdb/internal/fieldtag"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -13,6 +19,20 @@ type Store struct {
 	db     *bolt.DB
 	encode EncodeFunc
 	decode DecodeFunc
+	types  *typeRegistry
+	// defaultEncoder is set when DefaultEncode is used, whose encoding of signed integers sorts like the integers
+	defaultEncoder bool
+
+	// reflection metadata cached per reflect.Type
+	storers   sync.Map // *anonStorer
+	keyFields sync.Map // *keyField
+
+	expiring    sync.Map // [type name]*expiringType
+	expiry      expiryOptions
+	sweeperLock sync.Mutex
+	closed      bool
+	stopSweeper chan struct{}
+	sweeperDone chan struct{}
 }
 
 // Options allows you set different options from the defaults
@@ -20,11 +40,30 @@ type Store struct {
 type Options struct {
 	Encoder EncodeFunc
 	Decoder DecodeFunc
+	// QualifiedTypeNames stores types in buckets named after their package path and name, so types with the
+	// same name from different packages don't share a bucket
+	QualifiedTypeNames bool
+	// GeneratedCodec encodes the records implementing Marshaler, like the types generated by cmd/mesondb-gen,
+	// with their codec instead of the Encoder.  Records already stored by the Encoder are still decoded by the
+	// Decoder, and DefaultDecode decodes the records encoded by the codec even once the option is unset
+	GeneratedCodec bool
+	// ExpiryInterval is the interval between two runs of the sweeper deleting expired records, one minute by
+	// default.  The sweeper is started once the store holds expiring records.  A negative interval disables it,
+	// expired records are still never returned
+	ExpiryInterval time.Duration
+	// ExpiryBatchSize is the maximum number of expired records deleted by one transaction, 1000 by default.
+	// The indexes of the types not used since the store was opened are unknown, so a batch of their records
+	// rewrites the index entries holding them by walking every index bucket of the type: that walk isn't bounded
+	// by the batch size and grows with the size of the indexes
+	ExpiryBatchSize int
+	// OnExpiryError is called with the errors met by the sweeper
+	OnExpiryError func(err error)
 	*bolt.Options
 }
 
 // Open opens or creates a bolthold file.
 func Open(filename string, mode os.FileMode, options *Options) (*Store, error) {
+	defaultEncoder := options == nil || options.Encoder == nil
 	options = fillOptions(options)
 
 	db, err := bolt.Open(filename, mode, options.Options)
@@ -32,11 +71,35 @@ func Open(filename string, mode os.FileMode, options *Options) (*Store, error) {
 		return nil, err
 	}
 
-	return &Store{
+	s := &Store{
 		db:     db,
 		encode: options.Encoder,
 		decode: options.Decoder,
-	}, nil
+		types:  newTypeRegistry(options.QualifiedTypeNames),
+		expiry: expiryOptions{
+			interval:  options.ExpiryInterval,
+			batchSize: options.ExpiryBatchSize,
+			onError:   options.OnExpiryError,
+		},
+
+		defaultEncoder: defaultEncoder,
+	}
+	if options.GeneratedCodec {
+		s.encode = codecEncode(s.encode)
+		s.decode = codecDecode(s.decode)
+	}
+
+	// records left by a previous process are swept before their types are used
+	names, err := s.expiringTypeNames()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(names) > 0 {
+		s.startSweeper()
+	}
+
+	return s, nil
 }
 
 // set any unspecified options to defaults
@@ -51,6 +114,12 @@ func fillOptions(options *Options) *Options {
 	if options.Decoder == nil {
 		options.Decoder = DefaultDecode
 	}
+	if options.ExpiryInterval == 0 {
+		options.ExpiryInterval = defaultExpiryInterval
+	}
+	if options.ExpiryBatchSize <= 0 {
+		options.ExpiryBatchSize = defaultExpiryBatchSize
+	}
 
 	return options
 }
@@ -60,8 +129,17 @@ func (s *Store) Bolt() *bolt.DB {
 	return s.db
 }
 
-// Close closes the bolt db
+// Close stops the expiry sweeper and closes the bolt db
 func (s *Store) Close() error {
+	s.sweeperLock.Lock()
+	stop := !s.closed && s.stopSweeper != nil
+	s.closed = true
+	s.sweeperLock.Unlock()
+
+	if stop {
+		close(s.stopSweeper)
+		<-s.sweeperDone
+	}
 	return s.db.Close()
 }
 
@@ -70,7 +148,10 @@ func (s *Store) Close() error {
 // if bucketName is nil, then we'll assume a bucketName of storer.Type()
 // if a bucketname is specified, then the data will be copied to the bolthold standard bucket of storer.Type()
 func (s *Store) ReIndex(exampleType interface{}, bucketName []byte) error {
-	storer := s.newStorer(exampleType)
+	storer, err := s.newStorer(exampleType)
+	if err != nil {
+		return err
+	}
 
 	return s.Bolt().Update(func(tx *bolt.Tx) error {
 		indexes := storer.Indexes()
@@ -137,7 +218,10 @@ func (s *Store) ReIndex(exampleType interface{}, bucketName []byte) error {
 
 // RemoveIndex removes an index from the store.
 func (s *Store) RemoveIndex(dataType interface{}, indexName string) error {
-	storer := s.newStorer(dataType)
+	storer, err := s.newStorer(dataType)
+	if err != nil {
+		return err
+	}
 	return s.Bolt().Update(func(tx *bolt.Tx) error {
 		return tx.DeleteBucket(indexBucketName(storer.Type(), indexName))
 
@@ -151,16 +235,28 @@ type Storer interface {
 	//SliceIndexes() map[string]SliceIndex // [indexname]sliceIndexFunc
 }
 
+// Indexer can be implemented instead of Storer to define the indexes of a type without reflection, the type is
+// still stored in the bucket it would be stored in without them.  It's implemented by the code generated with
+// cmd/mesondb-gen
+type Indexer interface {
+	Indexes() map[string]Index
+}
+
 // anonType is created from a reflection of an unknown interface. This is the default storer used
 type anonStorer struct {
 	rType   reflect.Type
+	name    string
+	key     string // name of the key field
+	expires func(record interface{}) time.Time
+	fields  map[string]string // [indexname]path of the indexed field, to detect duplicate index names
+	paths   map[string]string // [indexname]path of the indexed value, to find the index of a sort field
 	indexes map[string]Index
 	//sliceIndexes map[string]SliceIndex
 }
 
-// Type returns the name of the type as determined from the reflect package
+// Type returns the name of the type as determined from the reflect package, or registered in the store
 func (t *anonStorer) Type() string {
-	return t.rType.Name()
+	return t.name
 }
 
 // Indexes returns the Indexes determined by the reflect package on this type
@@ -174,89 +270,197 @@ func (t *anonStorer) Indexes() map[string]Index {
 //}
 
 // newStorer creates a type which satisfies the Storer interface based on reflection of the passed in dataType
-// if the Type doesn't meet the requirements of a Storer (i.e. doesn't have a name) it panics
+// if the Type doesn't meet the requirements of a Storer (i.e. doesn't have a name) it returns an error
 // You can avoid any reflection costs, by implementing the Storer interface on a type
-func (s *Store) newStorer(dataType interface{}) Storer {
+func (s *Store) newStorer(dataType interface{}) (Storer, error) {
+	tp := reflect.TypeOf(dataType)
+	if tp == nil {
+		return nil, errors.New("Invalid Type for Storer.  Type is nil")
+	}
+
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
 	str, ok := dataType.(Storer)
 
 	if ok {
-		return str
+		name, ok := s.types.registered(tp)
+		if !ok {
+			// the name of the Storer is validated and reserved like the name of any other type
+			name = str.Type()
+			if err := s.types.add(tp, name); err != nil {
+				return nil, err
+			}
+		}
+		if name != str.Type() {
+			str = &registeredStorer{Storer: str, name: name}
+		}
+		s.trackExpiry(str, tp)
+		return str, nil
 	}
 
-	tp := reflect.TypeOf(dataType)
+	if storer, ok := s.storers.Load(tp); ok {
+		return storer.(*anonStorer), nil
+	}
 
-	for tp.Kind() == reflect.Ptr {
-		tp = tp.Elem()
+	name, err := s.types.typeName(tp)
+	if err != nil {
+		return nil, err
 	}
+	storer, err := reflectStorer(dataType, tp, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.cacheStorer(storer), nil
+}
 
+// reflectStorer returns the storer of tp, the type of example, stored in the bucket name.  It's built with
+// reflection, using the indexes of example if it's an Indexer
+func reflectStorer(example interface{}, tp reflect.Type, name string) (*anonStorer, error) {
 	storer := &anonStorer{
 		rType:   tp,
+		name:    name,
+		fields:  make(map[string]string),
+		paths:   make(map[string]string),
 		indexes: make(map[string]Index),
 		//sliceIndexes: make(map[string]SliceIndex),
 	}
 
 	if storer.rType.Name() == "" {
-		panic("Invalid Type for Storer.  Type is unnamed")
+		return nil, errors.New("Invalid Type for Storer.  Type is unnamed")
 	}
 
 	if storer.rType.Kind() != reflect.Struct {
-		panic("Invalid Type for Storer.  BoltHold only works with structs")
+		return nil, errors.New("Invalid Type for Storer.  BoltHold only works with structs")
 	}
 
+	visited := map[reflect.Type]bool{storer.rType: true}
 	for i := 0; i < storer.rType.NumField(); i++ {
-		storer.addIndex(storer.rType.Field(i), s)
+		err := storer.addIndex(storer.rType.Field(i), nil, nil, visited)
+		if err != nil {
+			return nil, err
+		}
 	}
+	if indexer, ok := example.(Indexer); ok {
+		storer.indexes = indexer.Indexes()
+	}
+	return storer, nil
+}
 
-	return storer
+// cacheStorer caches storer for its type, unless another storer was cached first, and returns the cached one
+func (s *Store) cacheStorer(storer *anonStorer) *anonStorer {
+	cached, _ := s.storers.LoadOrStore(storer.rType, storer)
+	s.trackExpiry(cached.(*anonStorer), storer.rType)
+	return cached.(*anonStorer)
 }
 
-func (t *anonStorer) addIndex(field reflect.StructField, store *Store) {
-	if field.Anonymous {
-		anonType := field.Type
-		if anonType.Kind() == reflect.Ptr {
-			anonType = anonType.Elem()
-		}
-		for j := 0; j < anonType.NumField(); j++ {
-			t.addIndex(anonType.Field(j), store)
+// keyField is the field of a type tagged with boltholdKey, or the key option of the mesondb tag
+type keyField struct {
+	index int
+	tp    reflect.Type
+}
+
+// keyField returns the field of tp tagged as the key, or nil if there isn't one
+func (s *Store) keyField(tp reflect.Type) *keyField {
+	if field, ok := s.keyFields.Load(tp); ok {
+		return field.(*keyField)
+	}
+
+	var field *keyField
+	if tp.Kind() == reflect.Struct {
+		for i := 0; i < tp.NumField(); i++ {
+			if tag, _ := fieldtag.Parse(tp.Field(i).Tag); tag.Key {
+				field = &keyField{index: i, tp: tp.Field(i).Type}
+				break
+			}
 		}
-		return
 	}
 
-	if strings.Contains(string(field.Tag), BoltholdIndexTag) {
-		indexName := field.Tag.Get(BoltholdIndexTag)
+	s.keyFields.Store(tp, field)
+	return field
+}
 
-		if indexName == "" {
-			indexName = field.Name
-		}
+// keyAccessor returns the KeyAccessor implemented by record or its address
+func keyAccessor(record reflect.Value) (KeyAccessor, bool) {
+	if record.Kind() != reflect.Ptr && record.CanAddr() {
+		record = record.Addr()
+	}
+	if record.Kind() != reflect.Ptr || record.IsNil() {
+		return nil, false
+	}
+	accessor, ok := record.Interface().(KeyAccessor)
+	return accessor, ok
+}
 
-		t.indexes[indexName] = Index{
-			IndexFunc: func(name string, value interface{}) ([]byte, error) {
-				val := findIndexValue(name, value, BoltholdIndexTag)
-				if val == nil {
-					return nil, nil
-				}
-				return store.encode(val)
-			},
-			Unique: false,
-		}
-	} else if strings.Contains(string(field.Tag), BoltholdUniqueTag) {
-		indexName := field.Tag.Get(BoltholdUniqueTag)
+// decodeKey decodes the encoded key into the key field of record, a pointer to a struct, if it has one
+func (s *Store) decodeKey(key []byte, record reflect.Value) error {
+	if accessor, ok := keyAccessor(record); ok {
+		return s.decode(key, accessor.BoltholdKey())
+	}
+	for record.Kind() == reflect.Ptr {
+		record = record.Elem()
+	}
+	field := s.keyField(record.Type())
+	if field == nil {
+		return nil
+	}
+	return s.decode(key, record.Field(field.index).Addr().Interface())
+}
+
+// addIndex adds the indexes defined by the tags of field.  parent is the path from the stored type to the
+// struct holding the field and prefix the matching index name prefix, which skips embedded structs.
+// Nested structs (and pointers to them) are walked, so a tag defined on them indexes the value at the path
+// from the stored type under a dotted name, for instance "P.Name"
+func (t *anonStorer) addIndex(field reflect.StructField, parent, prefix []string,
+	visited map[reflect.Type]bool) error {
+	if field.PkgPath != "" && !field.Anonymous {
+		// unexported
+		return nil
+	}
 
-		if indexName == "" {
-			indexName = field.Name
+	path := append(append([]string{}, parent...), field.Name)
+
+	tag, err := fieldtag.Parse(field.Tag)
+	if err != nil {
+		return fmt.Errorf("invalid tag on %s.%s: %s", t.rType.Name(), strings.Join(path, "."), err)
+	}
+	if tag.Key && len(parent) == 0 {
+		if t.key != "" {
+			return fmt.Errorf("%s has two key fields: %s and %s", t.rType.Name(), t.key, field.Name)
+		}
+		t.key = field.Name
+	}
+	if tag.Expires && len(parent) == 0 {
+		if err := t.setExpires(field); err != nil {
+			return err
 		}
+	}
+	if tag.Index || tag.Unique {
+		return t.addTaggedIndex(field, tag, prefix, path)
+	}
 
-		t.indexes[indexName] = Index{
-			IndexFunc: func(name string, value interface{}) ([]byte, error) {
-				val := findIndexValue(name, value, BoltholdUniqueTag)
-				if val == nil {
-					return nil, nil
-				}
-				return store.encode(val)
-			},
-			Unique: true,
+	nestedType := field.Type
+	if nestedType.Kind() == reflect.Ptr {
+		nestedType = nestedType.Elem()
+	}
+	if nestedType.Kind() != reflect.Struct || visited[nestedType] {
+		return nil
+	}
+
+	if !field.Anonymous {
+		prefix = path
+	}
+
+	visited[nestedType] = true
+	for j := 0; j < nestedType.NumField(); j++ {
+		err := t.addIndex(nestedType.Field(j), path, prefix, visited)
+		if err != nil {
+			return err
 		}
 	}
+	delete(visited, nestedType)
+
 	//if strings.Contains(string(field.Tag), BoltholdSliceIndexTag) {
 	//	indexName := field.Tag.Get(BoltholdSliceIndexTag)
 	//
@@ -296,36 +500,195 @@ func (t *anonStorer) addIndex(field reflect.StructField, store *Store) {
 	//		return indexValue, nil
 	//	}
 	//}
+
+	return nil
 }
 
-// returns the value in the field with the matching indexStruct tag
-func findIndexValue(name string, value interface{}, tag string) interface{} {
-	val := reflect.ValueOf(value)
-	if val.Kind() == reflect.Ptr && val.IsNil() {
-		return nil
+// setExpires makes field, a time.Time or *time.Time, the expiry time of the records
+func (t *anonStorer) setExpires(field reflect.StructField) error {
+	if t.expires != nil {
+		return fmt.Errorf("%s has two expires fields", t.rType.Name())
+	}
+
+	timeType := reflect.TypeOf(time.Time{})
+	if field.Type != timeType && field.Type != reflect.PtrTo(timeType) {
+		return fmt.Errorf("expires field %s.%s must be a time.Time or *time.Time, not %s", t.rType.Name(),
+			field.Name, field.Type)
+	}
+
+	index := field.Index
+	t.expires = func(record interface{}) time.Time {
+		value := reflect.ValueOf(record)
+		if !derefValue(&value) {
+			return time.Time{}
+		}
+		value = value.FieldByIndex(index)
+		if !derefValue(&value) {
+			return time.Time{}
+		}
+		return value.Interface().(time.Time)
 	}
-	for val.Kind() == reflect.Ptr {
-		val = val.Elem()
+	return nil
+}
+
+// addTaggedIndex adds the index described by the tags of field
+func (t *anonStorer) addTaggedIndex(field reflect.StructField, tag fieldtag.Tag, prefix, path []string) error {
+	indexName := tag.Name
+	if indexName == "" {
+		indexName = field.Name
+	}
+	indexName = strings.Join(append(append([]string{}, prefix...), indexName), ".")
+
+	if existing, ok := t.fields[indexName]; ok {
+		return fmt.Errorf("%s has two indexes named %s: on %s and %s", t.rType.Name(), indexName, existing,
+			strings.Join(path, "."))
+	}
+	t.fields[indexName] = strings.Join(path, ".")
+
+	if tag.Path != "" {
+		path = strings.Split(tag.Path, ".")
+	}
+	t.paths[indexName] = strings.Join(path, ".")
+	steps, fieldType, err := compilePath(t.rType, path)
+	if err != nil {
+		return fmt.Errorf("invalid index path for %s.%s: %s", t.rType.Name(), field.Name, err)
 	}
 
-	valType := val.Type()
-	for i := 0; i < valType.NumField(); i++ {
-		if valType.Field(i).Anonymous {
-			anonVal := findIndexValue(name, val.Field(i).Interface(), tag)
+	if tag.Collate != "" && fieldtag.Collations[tag.Collate] != nil {
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() != reflect.String {
+			return fmt.Errorf("collation %s of index %s only applies to strings, not %s", tag.Collate,
+				indexName, fieldType)
+		}
+	}
+
+	index := Index{
+		ValueFunc: func(value interface{}) interface{} {
+			return findPathValue(value, steps)
+		},
+		Unique:     tag.Unique,
+		OmitZero:   tag.OmitZero,
+		IgnoreZero: tag.IgnoreZero,
+		Collate:    tag.Collate,
+		Desc:       tag.Desc,
+	}
+
+	t.indexes[indexName] = index
+	return nil
+}
+
+// pathStep is a precomputed step of an index path, either a struct field index sequence (more than one index
+// for fields promoted from embedded structs) or a map key
+type pathStep struct {
+	field []int
+	key   reflect.Value
+}
 
-			if anonVal != nil {
-				return anonVal
+// compilePath resolves path, starting at tp, into steps which can be walked without looking up field names.
+// Each step of the path is either a struct field name or a map key, pointers are followed.
+// It also returns the type found at the end of the path
+func compilePath(tp reflect.Type, path []string) ([]pathStep, reflect.Type, error) {
+	steps := make([]pathStep, 0, len(path))
+	for _, step := range path {
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		switch tp.Kind() {
+		case reflect.Struct:
+			field, ok := tp.FieldByName(step)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s has no field %s", tp, step)
+			}
+			steps = append(steps, pathStep{field: field.Index})
+			tp = field.Type
+		case reflect.Map:
+			key, err := mapKey(tp.Key(), step)
+			if err != nil {
+				return nil, nil, err
 			}
-			continue
+			steps = append(steps, pathStep{key: key})
+			tp = tp.Elem()
+		default:
+			return nil, nil, fmt.Errorf("can't look up %s in %s", step, tp)
 		}
-		field := valType.Field(i)
-		if strings.Contains(string(field.Tag), tag) {
-			if field.Tag.Get(tag) == name || field.Name == name {
-				return val.Field(i).Interface()
+	}
+	return steps, tp, nil
+}
+
+// findPathValue returns the value found at the end of path, or nil if a pointer, interface or map along the
+// path is nil or doesn't hold the value
+func findPathValue(value interface{}, path []pathStep) interface{} {
+	val := reflect.ValueOf(value)
+
+	for _, step := range path {
+		if !derefValue(&val) {
+			return nil
+		}
+
+		if step.key.IsValid() {
+			if val.Kind() != reflect.Map {
+				return nil
 			}
+			val = val.MapIndex(step.key)
+		} else {
+			if val.Kind() != reflect.Struct {
+				return nil
+			}
+			// walk the index manually so a nil embedded pointer doesn't panic
+			for i, x := range step.field {
+				if i > 0 && !derefValue(&val) {
+					return nil
+				}
+				val = val.Field(x)
+			}
+		}
+
+		if !val.IsValid() {
+			return nil
 		}
 	}
-	return nil
+
+	if (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil() {
+		return nil
+	}
+	if !val.IsValid() || !val.CanInterface() {
+		return nil
+	}
+	return val.Interface()
+}
+
+// derefValue follows pointers and interfaces, returning false if one of them is nil
+func derefValue(val *reflect.Value) bool {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return false
+		}
+		*val = val.Elem()
+	}
+	return val.IsValid()
+}
+
+// mapKey converts a path step into a key for a map with keys of type keyType
+func mapKey(keyType reflect.Type, step string) (reflect.Value, error) {
+	switch keyType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(step).Convert(keyType), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(step, 10, keyType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(i).Convert(keyType), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(step, 10, keyType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(u).Convert(keyType), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported map key type %s", keyType)
 }
 
 // BucketSource is the source of a bucket for running a query or updating data
@@ -0,0 +1,440 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type nestedOwner struct {
+	Name string `boltholdIndex:"Name"`
+}
+
+type nestedBase struct {
+	Group string `boltholdIndex:"Group"`
+}
+
+type nestedRecord struct {
+	*nestedBase
+	ID     string
+	Owner  *nestedOwner
+	P      nestedOwner       `boltholdIndex:"owner_name,path=P.Name"`
+	Labels map[string]string `boltholdIndex:"env,path=Labels.env"`
+}
+
+func Test_nestedIndex(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	records := []nestedRecord{
+		{nestedBase: &nestedBase{"a"}, ID: "1", Owner: &nestedOwner{"bob"}, P: nestedOwner{"x"},
+			Labels: map[string]string{"env": "prod"}},
+		{ID: "2", P: nestedOwner{"y"}},
+		{nestedBase: &nestedBase{"b"}, ID: "3", Owner: &nestedOwner{"bob"}, P: nestedOwner{"x"},
+			Labels: map[string]string{"env": "dev"}},
+	}
+	for _, r := range records {
+		if err := store.Insert(r.ID, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		index string
+		value interface{}
+		ids   []string
+	}{
+		{"Owner.Name", "bob", []string{"1", "3"}},
+		{"owner_name", "x", []string{"1", "3"}},
+		{"owner_name", "y", []string{"2"}},
+		{"env", "dev", []string{"3"}},
+		{"Group", "a", []string{"1"}},
+	}
+
+	for _, test := range tests {
+		var result []nestedRecord
+		err := store.Find(&result, NewQuery(test.index).Equal(test.value))
+		if err != nil {
+			t.Fatalf("%s: %s", test.index, err)
+		}
+		if len(result) != len(test.ids) {
+			t.Fatalf("%s: expected %d records, got %d", test.index, len(test.ids), len(result))
+		}
+		for i := range result {
+			if result[i].ID != test.ids[i] {
+				t.Fatalf("%s: expected record %s, got %s", test.index, test.ids[i], result[i].ID)
+			}
+		}
+	}
+}
+
+type namedBucketRecord struct {
+	_    struct{} `boltholdBucket:"named"`
+	Name string   `boltholdIndex:"Name"`
+}
+
+type methodBucketRecord struct {
+	Name string
+}
+
+func (methodBucketRecord) BoltholdBucket() string {
+	return "method:named"
+}
+
+type duplicateIndexRecord struct {
+	A string `boltholdIndex:"Name"`
+	B string `boltholdIndex:"Name"`
+}
+
+type colonStorer struct{}
+
+func (colonStorer) Type() string { return "a:b" }
+
+func (colonStorer) Indexes() map[string]Index { return nil }
+
+type shortStorer struct{}
+
+func (shortStorer) Type() string { return "named" }
+
+func (shortStorer) Indexes() map[string]Index { return nil }
+
+func Test_typeRegistry(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	{
+		type Record struct {
+			Name string `boltholdIndex:"Name"`
+		}
+		if err := store.Register(Record{}, "first.Record"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Insert("1", Record{"a"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Register(&Record{}, "other"); !errors.Is(err, ErrTypeConflict) {
+			t.Fatalf("expected ErrTypeConflict registering a type twice, got %v", err)
+		}
+	}
+
+	{
+		type Record struct {
+			Name string `boltholdIndex:"Name"`
+		}
+		if err := store.Register(Record{}, "first.Record"); !errors.Is(err, ErrTypeConflict) {
+			t.Fatalf("expected ErrTypeConflict reusing a name, got %v", err)
+		}
+		// unregistered, uses its own type name
+		if err := store.Insert("1", Record{"b"}); err != nil {
+			t.Fatal(err)
+		}
+		var result []Record
+		if err := store.Find(&result, NewQuery("Name").Equal("b")); err != nil || len(result) != 1 {
+			t.Fatalf("expected 1 record, got %d (%v)", len(result), err)
+		}
+	}
+
+	{
+		// a third type with the same name can't share the implicit "Record" bucket
+		type Record struct {
+			Name string
+		}
+		if err := store.Insert("1", Record{"c"}); !errors.Is(err, ErrTypeConflict) {
+			t.Fatalf("expected ErrTypeConflict sharing a bucket, got %v", err)
+		}
+	}
+
+	if err := store.Insert("1", namedBucketRecord{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("1", methodBucketRecord{Name: "a"}); err == nil {
+		t.Fatal("expected an error for a bucket name containing ':'")
+	}
+
+	// a failed registration doesn't keep the name
+	if err := store.Register(duplicateIndexRecord{}, "duplicate"); err == nil {
+		t.Fatal("expected an error registering a type with two indexes of the same name")
+	}
+	if err := store.Register(keyedRecord{}, "duplicate"); err != nil {
+		t.Fatalf("expected the name of a failed registration to be free, got %v", err)
+	}
+	if err := store.Register(colonStorer{}, "storer"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the names of unregistered Storers are checked like the others
+	if err := store.Insert("1", &colonStorer{}); err != nil {
+		t.Fatalf("expected the registered name of the Storer to be used, got %v", err)
+	}
+	if err := openTestStore(t, nil).Insert("1", colonStorer{}); err == nil {
+		t.Fatal("expected an error for a Storer type containing ':'")
+	}
+	if err := store.Insert("2", shortStorer{}); !errors.Is(err, ErrTypeConflict) {
+		t.Fatalf("expected ErrTypeConflict for a Storer using the bucket of another type, got %v", err)
+	}
+
+	err := store.Bolt().View(func(tx *bolt.Tx) error {
+		for _, name := range []string{"first.Record", "Record", "named", "_index:named:Name", "storer"} {
+			if tx.Bucket([]byte(name)) == nil {
+				t.Errorf("bucket %s doesn't exist", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type keyedRecord struct {
+	Name string
+	Key  string `boltholdKey:"Key"`
+}
+
+func Test_storerCache(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	var wg sync.WaitGroup
+	storers := make([]Storer, 8)
+	for i := range storers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			storer, err := store.newStorer(&nestedRecord{})
+			if err != nil {
+				t.Error(err)
+			}
+			storers[i] = storer
+		}(i)
+	}
+	wg.Wait()
+
+	for _, storer := range storers[1:] {
+		if storer != storers[0] {
+			t.Fatal("expected the storer to be cached")
+		}
+	}
+
+	storer, err := store.newStorer(nestedRecord{})
+	if err != nil || storer != storers[0] {
+		t.Fatalf("expected the cached storer for a value, got %v", err)
+	}
+
+	field := store.keyField(reflect.TypeOf(keyedRecord{}))
+	if field == nil || field.index != 1 || field.tp.Kind() != reflect.String {
+		t.Fatalf("unexpected key field %+v", field)
+	}
+	if store.keyField(reflect.TypeOf(nestedRecord{})) != nil {
+		t.Fatal("expected no key field")
+	}
+
+	record := &keyedRecord{Name: "a"}
+	if err := store.Insert("k", record); err != nil || record.Key != "k" {
+		t.Fatalf("expected the key field to be set on insert, got %q (%v)", record.Key, err)
+	}
+	var result keyedRecord
+	if err := store.Get("k", &result); err != nil || result.Key != "k" {
+		t.Fatalf("expected the key field to be set on get, got %q (%v)", result.Key, err)
+	}
+}
+
+type taggedRecord struct {
+	ID    string `mesondb:"key"`
+	Name  string `mesondb:"index,collate=nocase"`
+	Email string `mesondb:"unique,name=mail"`
+	Group string `mesondb:"index,omitzero"`
+}
+
+func Test_mesondbTag(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	records := []taggedRecord{
+		{Name: "Alice", Email: "a@example.com", Group: "x"},
+		{Name: "ALICE", Email: "b@example.com"},
+		{Name: "bob", Email: "c@example.com", Group: "x"},
+	}
+	for i := range records {
+		if err := store.Insert(string(rune('1'+i)), &records[i]); err != nil {
+			t.Fatal(err)
+		}
+		if records[i].ID != string(rune('1'+i)) {
+			t.Fatalf("expected the key field to be set, got %q", records[i].ID)
+		}
+	}
+
+	tests := []struct {
+		query *Query
+		count int
+	}{
+		{NewQuery("Name").Equal("alice"), 2},
+		{NewQuery("Name").Equal("Bob"), 1},
+		{NewQuery("mail").Equal("b@example.com"), 1},
+		{NewQuery("Group").Range(), 2},
+	}
+	for i, test := range tests {
+		count, err := store.Count(&taggedRecord{}, test.query)
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if count != test.count {
+			t.Fatalf("query %d: expected %d records, got %d", i, test.count, count)
+		}
+	}
+
+	if err := store.Insert("4", taggedRecord{Email: "a@example.com"}); !errors.Is(err, ErrUniqueExists) {
+		t.Fatalf("expected ErrUniqueExists, got %v", err)
+	}
+}
+
+func Test_mesondbTagErrors(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	type UnknownOption struct {
+		Name string `mesondb:"index,sorted"`
+	}
+	type NotIndexed struct {
+		Name string `mesondb:"omitzero"`
+	}
+	type IgnoreZeroIndex struct {
+		Name string `mesondb:"index,ignorezero"`
+	}
+	type UnknownCollation struct {
+		Name string `mesondb:"index,collate=french"`
+	}
+	type CollateNumber struct {
+		Size int `mesondb:"index,collate=nocase"`
+	}
+	type Mixed struct {
+		Name string `mesondb:"index" boltholdIndex:"Name"`
+	}
+	type Duplicate struct {
+		Name  string `mesondb:"index"`
+		Other string `mesondb:"index,name=Name"`
+	}
+	type TwoKeys struct {
+		ID    string `mesondb:"key"`
+		Other string `mesondb:"key"`
+	}
+	type ValuedOmitZero struct {
+		Name string `mesondb:"index,omitzero=x"`
+	}
+	type ValuedIgnoreZero struct {
+		Name string `mesondb:"unique,ignorezero=true"`
+	}
+	type ValuedDesc struct {
+		Name string `mesondb:"index,desc=1"`
+	}
+	type LegacyValuedDesc struct {
+		Name string `boltholdIndex:"Name,desc=1"`
+	}
+	type LegacyIndexUnique struct {
+		Name string `boltholdIndex:"Name" boltholdUnique:"Name"`
+	}
+
+	for _, record := range []interface{}{UnknownOption{}, NotIndexed{}, IgnoreZeroIndex{}, UnknownCollation{},
+		CollateNumber{}, Mixed{}, Duplicate{}, TwoKeys{}, ValuedOmitZero{}, ValuedIgnoreZero{}, ValuedDesc{},
+		LegacyValuedDesc{}, LegacyIndexUnique{}} {
+		if err := store.Register(record, ""); err == nil {
+			t.Fatalf("expected an error registering %T", record)
+		}
+		if err := store.Insert("1", record); err == nil {
+			t.Fatalf("expected an error for %T", record)
+		}
+	}
+
+	// the bolthold tags are only read from their own key
+	type OtherTags struct {
+		ID   string `mesondb:"key" json:"boltholdKey"`
+		Name string `mesondb:"index" json:"boltholdIndex"`
+		Note string `json:"boltholdUnique"`
+	}
+	if err := store.Register(OtherTags{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("a", OtherTags{Name: "x", Note: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("b", OtherTags{Name: "x", Note: "n"}); err != nil {
+		t.Fatalf("expected Note not to be a unique index, got %v", err)
+	}
+	var result []OtherTags
+	if err := store.Find(&result, NewQuery("Name").Equal("x")); err != nil || len(result) != 2 ||
+		result[0].ID != "a" {
+		t.Fatalf("expected 2 records keyed by ID, got %+v (%v)", result, err)
+	}
+}
+
+type descRecord struct {
+	ID       int   `mesondb:"key"`
+	Time     int64 `mesondb:"index"`
+	TimeDesc int64 `mesondb:"index,desc,path=Time"`
+}
+
+func Test_descIndex(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	for i := 0; i < 30; i++ {
+		if err := store.Insert(i, descRecord{Time: int64(i*7%11 - 5)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var criteria [][]*Criterion
+	criteria = append(criteria, nil)
+	for _, op := range []Operator{OpGt, OpGe, OpLt, OpLe} {
+		for _, v := range []int64{-6, -5, 0, 3, 5, 6} {
+			criteria = append(criteria, []*Criterion{Condition(op, v)})
+		}
+	}
+	for _, min := range []*Criterion{Condition(OpGt, int64(-2)), Condition(OpGe, int64(-2))} {
+		for _, max := range []*Criterion{Condition(OpLt, int64(3)), Condition(OpLe, int64(3))} {
+			criteria = append(criteria, []*Criterion{min, max}, []*Criterion{max, min})
+		}
+	}
+
+	ids := func(index string, c []*Criterion, reverse bool, offset, limit int) []int {
+		q := NewQuery(index).Range(c...).Offset(offset).Limit(limit)
+		if reverse {
+			q.Desc()
+		}
+		var result []descRecord
+		if err := store.Find(&result, q); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, len(result))
+		for i := range result {
+			ids[i] = result[i].ID
+		}
+		return ids
+	}
+
+	for _, c := range criteria {
+		for _, reverse := range []bool{false, true} {
+			for _, page := range [][2]int{{0, 0}, {2, 0}, {0, 5}, {3, 4}} {
+				expected := ids("Time", c, reverse, page[0], page[1])
+				got := ids("TimeDesc", c, reverse, page[0], page[1])
+				if !reflect.DeepEqual(expected, got) {
+					t.Fatalf("criteria %v reverse %v page %v: expected %v, got %v", c, reverse, page, expected,
+						got)
+				}
+			}
+		}
+	}
+
+	var result []descRecord
+	if err := store.Find(&result, NewQuery("TimeDesc").Equal(int64(2))); err != nil || len(result) == 0 {
+		t.Fatalf("expected records equal to 2, got %d (%v)", len(result), err)
+	}
+
+	for _, value := range [][]byte{nil, {0}, {0, 0, 1}, {1, 0xFF, 0}, []byte("value")} {
+		decoded, err := descValue(descKey(value))
+		if err != nil || !bytes.Equal(decoded, value) {
+			t.Fatalf("expected %v, got %v (%v)", value, decoded, err)
+		}
+	}
+	if bytes.Compare(descKey([]byte{1}), descKey([]byte{1, 0})) <= 0 {
+		t.Fatal("expected a longer value to sort first")
+	}
+}
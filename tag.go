@@ -0,0 +1,28 @@
+package meson_bolt_localdb
+
+import "github.com/daqnext/meson-bolt-localdb/internal/fieldtag"
+
+// MesonDBTag is the struct tag configuring the key and indexes of a type in one place, as a comma separated list
+// of options:
+//
+//	key           the field is the key of the record, like boltholdKey
+//	expires       the field, a time.Time or *time.Time, is the time the record expires at
+//	index         the field is indexed, like boltholdIndex
+//	unique        the field is indexed with a unique constraint, like boltholdUnique
+//	name=Owner    name of the index, defaults to the field name
+//	path=P.Name   index the value found at this path from the stored type instead of the field
+//	omitzero      don't index records holding the zero value
+//	ignorezero    index records holding the zero value, but don't enforce the unique constraint on them
+//	desc          store the index in descending order, making queries sorted by descending values faster
+//	collate=nocase  compare strings without case, the only other collation is binary, the default
+//
+// For instance `mesondb:"key,index"` or `mesondb:"unique,name=ExtID,ignorezero"`
+const MesonDBTag = fieldtag.MesonDB
+
+// collate applies the named collation to value
+func collate(collation string, value interface{}) interface{} {
+	if fn := fieldtag.Collations[collation]; fn != nil {
+		return fn(value)
+	}
+	return value
+}
@@ -0,0 +1,361 @@
+package meson_bolt_localdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const expiryBucketPrefix = "_expiry"
+
+// sub buckets of the expiry bucket of a type
+var (
+	expiryByTime = []byte("time") // [expiry time][key] => nil, walked by the sweeper
+	expiryByKey  = []byte("key")  // [key] => [expiry time], used to skip expired records
+)
+
+const (
+	defaultExpiryInterval  = time.Minute
+	defaultExpiryBatchSize = 1000
+)
+
+// ExpiringStorer is a Storer whose records expire, like the types with an expires tag
+type ExpiringStorer interface {
+	Storer
+	// BoltholdExpiry returns the time record expires at, or the zero time if it never expires
+	BoltholdExpiry(record interface{}) time.Time
+}
+
+// expiringType is a type with expiring records seen by the store, the sweeper deletes its expired records
+type expiringType struct {
+	storer Storer
+	rType  reflect.Type
+}
+
+// expiryOptions configure the sweeper
+type expiryOptions struct {
+	interval  time.Duration
+	batchSize int
+	onError   func(err error)
+}
+
+// storerExpiry returns the function returning the expiry time of the records of storer, or nil if they never
+// expire
+func storerExpiry(storer Storer) func(record interface{}) time.Time {
+	switch storer := storer.(type) {
+	case *anonStorer:
+		return storer.expires
+	case *registeredStorer:
+		return storerExpiry(storer.Storer)
+	case ExpiringStorer:
+		return storer.BoltholdExpiry
+	}
+	return nil
+}
+
+// trackExpiry records tp as an expiring type if the records of storer expire
+func (s *Store) trackExpiry(storer Storer, tp reflect.Type) {
+	if storerExpiry(storer) == nil {
+		return
+	}
+	if _, ok := s.expiring.Load(storer.Type()); !ok {
+		if _, loaded := s.expiring.LoadOrStore(storer.Type(), &expiringType{storer: storer, rType: tp}); !loaded {
+			s.startSweeper()
+		}
+	}
+}
+
+// expiryBucketName returns the name of the bolt bucket where the expiry index of a type is stored
+func expiryBucketName(typeName string) []byte {
+	return []byte(expiryBucketPrefix + ":" + typeName)
+}
+
+// encodeExpiry encodes t so encoded times sort in time order
+func encodeExpiry(t time.Time) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t.UnixNano())^(1<<63))
+	return b[:]
+}
+
+// updateExpiry adds or removes the entries of key in the expiry index of a type
+func (s *Store) updateExpiry(source BucketSource, typeName string, key []byte, expires time.Time,
+	delete bool) error {
+	if delete {
+		bkt := source.Bucket(expiryBucketName(typeName))
+		if bkt == nil {
+			return nil
+		}
+		byKey, byTime := bkt.Bucket(expiryByKey), bkt.Bucket(expiryByTime)
+		ts := byKey.Get(key)
+		if ts == nil {
+			return nil
+		}
+		err := byTime.Delete(append(append([]byte{}, ts...), key...))
+		if err != nil {
+			return err
+		}
+		return byKey.Delete(key)
+	}
+
+	if expires.IsZero() {
+		return nil
+	}
+
+	bkt, err := source.CreateBucketIfNotExists(expiryBucketName(typeName))
+	if err != nil {
+		return err
+	}
+	byKey, err := bkt.CreateBucketIfNotExists(expiryByKey)
+	if err != nil {
+		return err
+	}
+	byTime, err := bkt.CreateBucketIfNotExists(expiryByTime)
+	if err != nil {
+		return err
+	}
+
+	ts := encodeExpiry(expires)
+	err = byTime.Put(append(append([]byte{}, ts...), key...), nil)
+	if err != nil {
+		return err
+	}
+	return byKey.Put(key, ts)
+}
+
+// liveFunc returns a function telling whether the record of a key hasn't expired yet, or nil if the records of
+// storer never expire
+func (s *Store) liveFunc(source BucketSource, storer Storer) func(key []byte) bool {
+	if storerExpiry(storer) == nil {
+		return nil
+	}
+	bkt := source.Bucket(expiryBucketName(storer.Type()))
+	if bkt == nil {
+		return nil
+	}
+	byKey := bkt.Bucket(expiryByKey)
+	now := encodeExpiry(time.Now())
+	return func(key []byte) bool {
+		ts := byKey.Get(key)
+		return ts == nil || bytes.Compare(ts, now) > 0
+	}
+}
+
+// expired tells whether the record of key has expired
+func (s *Store) expired(source BucketSource, storer Storer, key []byte) bool {
+	live := s.liveFunc(source, storer)
+	return live != nil && !live(key)
+}
+
+// DeleteExpired deletes the expired records of all the types with expiring records in the store, and returns the
+// number of deleted records.  It's called periodically by the sweeper
+func (s *Store) DeleteExpired() (int, error) {
+	return s.deleteExpired(defaultExpiryBatchSize)
+}
+
+func (s *Store) deleteExpired(batchSize int) (int, error) {
+	names, err := s.expiringTypeNames()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, name := range names {
+		// the types not seen since the store was opened are swept without decoding their records
+		var tp *expiringType
+		if value, ok := s.expiring.Load(name); ok {
+			tp = value.(*expiringType)
+		}
+		for {
+			var n int
+			err := s.Bolt().Update(func(tx *bolt.Tx) error {
+				var err error
+				n, err = s.deleteExpiredBatch(tx, name, tp, batchSize)
+				return err
+			})
+			total += n
+			if err != nil {
+				return total, fmt.Errorf("deleting expired %s records: %w", name, err)
+			}
+			if n < batchSize {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// expiringTypeNames returns the names of the types with an expiry bucket, found from the root of the bolt db
+func (s *Store) expiringTypeNames() ([]string, error) {
+	var names []string
+	prefix := expiryBucketName("")
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		c := tx.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			names = append(names, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// deleteExpiredBatch deletes up to batchSize expired records of the type typeName and returns the number of
+// deleted records.  tp is nil if the store hasn't seen the type
+func (s *Store) deleteExpiredBatch(tx *bolt.Tx, typeName string, tp *expiringType, batchSize int) (int, error) {
+	bkt := tx.Bucket(expiryBucketName(typeName))
+	if bkt == nil {
+		return 0, nil
+	}
+
+	now := encodeExpiry(time.Now())
+	var keys [][]byte
+	c := bkt.Bucket(expiryByTime).Cursor()
+	for k, _ := c.First(); k != nil && len(keys) < batchSize; k, _ = c.Next() {
+		if bytes.Compare(k[:8], now) > 0 {
+			break
+		}
+		keys = append(keys, append([]byte{}, k[8:]...))
+	}
+
+	mainBkt := tx.Bucket([]byte(typeName))
+	var unindexed keyList
+	for _, key := range keys {
+		var value []byte
+		if mainBkt != nil {
+			value = mainBkt.Get(key)
+		}
+		if value == nil || tp == nil {
+			if value != nil {
+				if err := mainBkt.Delete(key); err != nil {
+					return 0, err
+				}
+				unindexed = append(unindexed, key)
+			}
+			// the record is gone, or its indexes are removed below, only remove its expiry
+			if err := s.updateExpiry(tx, typeName, key, time.Time{}, true); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		record := reflect.New(tp.rType)
+		if err := s.decode(value, record.Interface()); err != nil {
+			return 0, err
+		}
+		if err := mainBkt.Delete(key); err != nil {
+			return 0, err
+		}
+		// also removes the expiry of the record
+		if err := s.deleteIndexes(tp.storer, tx, key, record.Elem().Interface()); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(unindexed) > 0 {
+		if err := s.removeIndexedKeys(tx, typeName, sortKeys(unindexed)); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// removeIndexedKeys removes the sorted keys from all the index buckets of the type typeName, whose indexes are
+// unknown to the store.  Every entry of those buckets is decoded, whatever the number of keys
+func (s *Store) removeIndexedKeys(tx *bolt.Tx, typeName string, keys keyList) error {
+	prefix := indexBucketName(typeName, "")
+	var buckets [][]byte
+	c := tx.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		buckets = append(buckets, append([]byte{}, k...))
+	}
+
+	for _, name := range buckets {
+		bkt := tx.Bucket(name)
+		if bkt == nil {
+			continue
+		}
+		// the bucket can't be changed while it's walked
+		changed := make(map[string]keyList)
+		err := bkt.ForEach(func(indexKey, value []byte) error {
+			if value == nil {
+				return nil
+			}
+			var list keyList
+			if err := s.decode(value, &list); err != nil {
+				return err
+			}
+			if kept := subtractKeys(list, keys); len(kept) != len(list) {
+				changed[string(indexKey)] = kept
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for indexKey, list := range changed {
+			if len(list) == 0 {
+				err = bkt.Delete([]byte(indexKey))
+			} else {
+				var value []byte
+				value, err = s.encode(list)
+				if err == nil {
+					err = bkt.Put([]byte(indexKey), value)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subtractKeys returns the keys of the sorted list a not in the sorted list b
+func subtractKeys(a, b keyList) keyList {
+	result := make(keyList, 0, len(a))
+	for _, key := range a {
+		if !b.in(key) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// startSweeper starts the sweeper deleting expired records, unless it's already running or disabled, or the
+// store is read only or closed
+func (s *Store) startSweeper() {
+	if s.expiry.interval <= 0 || s.db.IsReadOnly() {
+		return
+	}
+
+	s.sweeperLock.Lock()
+	defer s.sweeperLock.Unlock()
+	if s.closed || s.stopSweeper != nil {
+		return
+	}
+	s.stopSweeper = make(chan struct{})
+	s.sweeperDone = make(chan struct{})
+	go s.sweep(s.expiry.interval, s.expiry.batchSize, s.expiry.onError)
+}
+
+// sweep deletes expired records every interval until stop is closed
+func (s *Store) sweep(interval time.Duration, batchSize int, onError func(error)) {
+	defer close(s.sweeperDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopSweeper:
+			return
+		case <-ticker.C:
+			if _, err := s.deleteExpired(batchSize); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package meson_bolt_localdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type expiringRecord struct {
+	ID      int       `mesondb:"key"`
+	Group   string    `mesondb:"index"`
+	Expires time.Time `mesondb:"expires"`
+}
+
+func Test_expiry(t *testing.T) {
+	store := openTestStore(t, &Options{ExpiryInterval: -1})
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	records := []expiringRecord{
+		{Group: "a", Expires: past},
+		{Group: "a", Expires: future},
+		{Group: "a"},
+		{Group: "b", Expires: past},
+	}
+	for i, r := range records {
+		if err := store.Insert(i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Get(0, &expiringRecord{}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for an expired record, got %v", err)
+	}
+	if err := store.Update(0, expiringRecord{Group: "a"}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound updating an expired record, got %v", err)
+	}
+	var record expiringRecord
+	if err := store.Get(1, &record); err != nil || record.ID != 1 {
+		t.Fatalf("expected the record 1, got %+v (%v)", record, err)
+	}
+
+	tests := []struct {
+		query *Query
+		count int
+	}{
+		{nil, 2},
+		{NewQuery(Key).Range(Condition(OpGe, 0)), 2},
+		{NewQuery(Key).Equal(3), 0},
+		{NewQuery("Group").Equal("a"), 2},
+		{NewQuery("Group").Range(), 2},
+		{NewQuery("Group").Range().Limit(1), 1},
+		{NewQuery("Group").Range().Offset(1), 1},
+	}
+	for i, test := range tests {
+		var result []expiringRecord
+		if err := store.Find(&result, test.query); err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if len(result) != test.count {
+			t.Fatalf("query %d: expected %d records, got %d", i, test.count, len(result))
+		}
+	}
+
+	// an expired record doesn't prevent inserting its key again
+	if err := store.Insert(3, expiringRecord{Group: "c", Expires: past}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.DeleteExpired()
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 expired records to be deleted, got %d (%v)", n, err)
+	}
+	err = store.Bolt().View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("expiringRecord")).Stats().KeyN; n != 2 {
+			t.Errorf("expected 2 records left, got %d", n)
+		}
+		if n := tx.Bucket([]byte("_index:expiringRecord:Group")).Stats().KeyN; n != 1 {
+			t.Errorf("expected only the group a left, got %d groups", n)
+		}
+		expiry := tx.Bucket(expiryBucketName("expiringRecord"))
+		if n := expiry.Bucket(expiryByTime).Stats().KeyN; n != 1 {
+			t.Errorf("expected 1 expiry left, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// updating the expiry time moves it in the expiry index
+	record.Expires = past
+	if err := store.Update(1, record); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.DeleteExpired(); err != nil || n != 1 {
+		t.Fatalf("expected the updated record to be deleted, got %d (%v)", n, err)
+	}
+	if count, err := store.Count(&expiringRecord{}, nil); err != nil || count != 1 {
+		t.Fatalf("expected 1 record left, got %d (%v)", count, err)
+	}
+}
+
+func Test_expirySweeper(t *testing.T) {
+	store := openTestStore(t, &Options{ExpiryInterval: 10 * time.Millisecond})
+
+	if err := store.Insert(1, expiringRecord{Expires: time.Now().Add(20 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var found bool
+		err := store.Bolt().View(func(tx *bolt.Tx) error {
+			found = tx.Bucket([]byte("expiringRecord")).Get(mustEncode(t, 1)) != nil
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sweeper to delete the expired record")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sweeperRunning tells whether the sweeper of store was started
+func sweeperRunning(store *Store) bool {
+	store.sweeperLock.Lock()
+	defer store.sweeperLock.Unlock()
+	return store.stopSweeper != nil
+}
+
+type expiringUniqueRecord struct {
+	ID      int       `mesondb:"key"`
+	Name    string    `mesondb:"unique"`
+	Group   string    `mesondb:"index"`
+	Expires time.Time `mesondb:"expires"`
+}
+
+func Test_expirySweeperStart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	store, err := Open(filename, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert("a", uniqueRecord{"a", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if sweeperRunning(store) {
+		t.Fatal("expected no sweeper without expiring records")
+	}
+
+	past := time.Now().Add(-time.Minute)
+	for i, r := range []expiringUniqueRecord{
+		{Name: "a", Group: "g", Expires: past},
+		{Name: "b", Group: "g"},
+		{Name: "c", Group: "h", Expires: past},
+	} {
+		if err := store.Insert(i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !sweeperRunning(store) {
+		t.Fatal("expected the sweeper to start once the store holds expiring records")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records left by the previous store are swept before their type is used
+	store, err = Open(filename, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if !sweeperRunning(store) {
+		t.Fatal("expected the sweeper to start for the expiring records in the file")
+	}
+	if n, err := store.DeleteExpired(); err != nil || n != 2 {
+		t.Fatalf("expected 2 expired records to be deleted, got %d (%v)", n, err)
+	}
+
+	var result []expiringUniqueRecord
+	if err := store.Find(&result, NewQuery("Group").Range()); err != nil || len(result) != 1 || result[0].ID != 1 {
+		t.Fatalf("expected only the record 1 to be left, got %+v (%v)", result, err)
+	}
+	if err := store.Insert(2, expiringUniqueRecord{Name: "c", Group: "g"}); err != nil {
+		t.Fatalf("expected the unique index of the expired record to be removed, got %v", err)
+	}
+	err = store.Bolt().View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("_index:expiringUniqueRecord:Name")).Stats().KeyN; n != 2 {
+			t.Errorf("expected 2 unique values left, got %d", n)
+		}
+		if n := tx.Bucket([]byte("_index:expiringUniqueRecord:Group")).Stats().KeyN; n != 1 {
+			t.Errorf("expected only the group g left, got %d groups", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type indexedExpiringRecord struct {
+	Group   string
+	Expires time.Time `mesondb:"expires"`
+}
+
+func (indexedExpiringRecord) Indexes() map[string]Index {
+	return map[string]Index{
+		"Group": {ValueFunc: func(value interface{}) interface{} {
+			return value.(indexedExpiringRecord).Group
+		}},
+	}
+}
+
+func Test_indexerExpiry(t *testing.T) {
+	store := openTestStore(t, &Options{ExpiryInterval: -1})
+
+	if err := store.Insert(1, indexedExpiringRecord{Group: "a", Expires: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Get(1, &indexedExpiringRecord{}); err != ErrNotFound {
+		t.Fatalf("expected the expiry of the Indexer to hide the record, got %v", err)
+	}
+	if n, err := store.DeleteExpired(); err != nil || n != 1 {
+		t.Fatalf("expected 1 expired record to be deleted, got %d (%v)", n, err)
+	}
+}
+
+func Test_expiryTagErrors(t *testing.T) {
+	store := openTestStore(t, nil)
+
+	type NotTime struct {
+		Expires int64 `mesondb:"expires"`
+	}
+	type TwoExpires struct {
+		A time.Time `mesondb:"expires"`
+		B time.Time `mesondb:"expires"`
+	}
+	for _, record := range []interface{}{NotTime{}, TwoExpires{}} {
+		if err := store.Insert(1, record); err == nil {
+			t.Fatalf("expected an error for %T", record)
+		}
+	}
+}
+
+func mustEncode(t *testing.T, value interface{}) []byte {
+	encoded, err := DefaultEncode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}